@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"example.com/web-service/internal/fsutil"
+)
+
+// ConflictPolicy decides what happens when a pasted entry already exists at the destination.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces existing files; existing directories are merged into.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictSkip      ConflictPolicy = "skip"
+	// ConflictRename picks a Finder-style free name such as "name 2.ext".
+	ConflictRename ConflictPolicy = "rename"
+	ConflictFail   ConflictPolicy = "fail"
+)
+
+// MaxRenameAttempts bounds the search for a free "name N.ext".
+const MaxRenameAttempts = 10000
+
+func parseConflictPolicy(s string) (ConflictPolicy, bool) {
+	switch policy := ConflictPolicy(s); policy {
+	case "":
+		return ConflictOverwrite, true
+	case ConflictOverwrite, ConflictSkip, ConflictRename, ConflictFail:
+		return policy, true
+	default:
+		return "", false
+	}
+}
+
+// resolveDest applies the policy to destDir/name. It returns the path to write to
+// and the action taken ("", "overwritten", "skipped" or "renamed"). Names come from
+// peers, so anything but a plain file name is refused.
+func resolveDest(destDir, name string, policy ConflictPolicy) (string, string, error) {
+	if !fsutil.IsPlainName(name) {
+		return "", "", fmt.Errorf("invalid file name: %q", name)
+	}
+	destPath := filepath.Join(destDir, name)
+	if !exists(destPath) {
+		return destPath, "", nil
+	}
+
+	switch policy {
+	case ConflictSkip:
+		return destPath, "skipped", nil
+	case ConflictRename:
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for n := 2; n <= MaxRenameAttempts; n++ {
+			candidate := filepath.Join(destDir, fmt.Sprintf("%s %d%s", base, n, ext))
+			if !exists(candidate) {
+				return candidate, "renamed", nil
+			}
+		}
+		return "", "", fmt.Errorf("no free name found for %s", name)
+	case ConflictFail:
+		return destPath, "", fmt.Errorf("destination already exists: %s", destPath)
+	default:
+		return destPath, "overwritten", nil
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveDestRejectsEscapingNames(t *testing.T) {
+	destDir := t.TempDir()
+	for _, name := range []string{"../.bashrc", "../../.bashrc", "sub/file", "/etc/passwd", "..", ".", ""} {
+		if path, _, err := resolveDest(destDir, name, ConflictOverwrite); err == nil {
+			t.Errorf("resolveDest accepted %q as %s", name, path)
+		}
+	}
+}
+
+func TestResolveDestPolicies(t *testing.T) {
+	destDir := t.TempDir()
+	existing := filepath.Join(destDir, "notes.txt")
+	if err := os.WriteFile(existing, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy     ConflictPolicy
+		wantPath   string
+		wantAction string
+		wantErr    bool
+	}{
+		{ConflictOverwrite, existing, "overwritten", false},
+		{ConflictSkip, existing, "skipped", false},
+		{ConflictRename, filepath.Join(destDir, "notes 2.txt"), "renamed", false},
+		{ConflictFail, existing, "", true},
+	}
+	for _, tt := range tests {
+		path, action, err := resolveDest(destDir, "notes.txt", tt.policy)
+		if path != tt.wantPath || action != tt.wantAction || (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %s, %q, %v", tt.policy, path, action, err)
+		}
+	}
+
+	if path, action, err := resolveDest(destDir, "new.txt", ConflictFail); err != nil || action != "" || path != filepath.Join(destDir, "new.txt") {
+		t.Errorf("free name: got %s, %q, %v", path, action, err)
+	}
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/models"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/store"
+	"example.com/web-service/internal/websocket"
+)
+
+const (
+	// InlineContentLimit is the largest representation sent inside the broadcast;
+	// bigger ones (e.g. images) are fetched from the origin on demand.
+	InlineContentLimit = 64 * 1024
+
+	// maxContentSize bounds a copyContent request, which is held in memory with the history
+	maxContentSize = 64 << 20
+)
+
+func HandleCopyContent(cfg *config.Config, clipboard *store.Store, hub *websocket.Hub, manager *websocket.ClientManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Println("Received copyContent request")
+
+		// Expecting JSON: { "representations": [ { "type": "text/plain", "text": "..." }, { "type": "image/png", "data": "<base64>" } ] }
+		var payload struct {
+			Representations []struct {
+				Type string `json:"type"`
+				Data []byte `json:"data"`
+				Text string `json:"text"` // Convenience for textual types instead of base64 data
+			} `json:"representations"`
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxContentSize)
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Content too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		if len(payload.Representations) == 0 {
+			http.Error(w, "Missing representations", http.StatusBadRequest)
+			return
+		}
+
+		reps := make([]models.Representation, 0, len(payload.Representations))
+		for _, rep := range payload.Representations {
+			if rep.Type == "" {
+				http.Error(w, "Missing representation type", http.StatusBadRequest)
+				return
+			}
+			data := rep.Data
+			if data == nil {
+				data = []byte(rep.Text)
+			}
+			reps = append(reps, models.Representation{Type: rep.Type, Data: data})
+		}
+
+		localIP := GetLocalIP()
+		entry, announced := clipboard.StoreLocalContent(reps, InlineContentLimit, localIP, cfg.HttpPort, cfg.ClientID)
+
+		// Broadcast to local clients and cloud servers
+		broadcastPerPeer(hub, manager, localIP, func(ip string) websocket.Message {
+			return websocket.Message{
+				Type: "copyContent",
+				Data: models.CopyContentData{
+					Representations: announced,
+					Index:           entry.Index,
+					IP:              ip,
+					Port:            cfg.HttpPort,
+					ClientID:        cfg.ClientID,
+					Clock:           entry.Clock,
+				},
+			}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Copy content received successfully",
+			"count":   len(reps),
+		})
+	}
+}
+
+// HandleGetClipboard describes the current clipboard, or with ?type=<mime> returns
+// that representation's content, fetching it from the origin peer if needed.
+func HandleGetClipboard(keys *pairing.Keystore, clipboard *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		entry, ok := clipboard.Current()
+
+		mimeType := r.URL.Query().Get("type")
+		if mimeType == "" {
+			kind := "empty"
+			if entry.Files != nil {
+				kind = "files"
+			} else if entry.Content != nil {
+				kind = "content"
+			}
+
+			entry = summarizeEntry(entry)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"kind":            kind,
+				"index":           entry.Index,
+				"files":           entry.Files,
+				"representations": entry.Content,
+				"ip":              entry.IP,
+				"port":            entry.Port,
+				"clientId":        entry.ClientID,
+			})
+			return
+		}
+
+		if !ok {
+			http.Error(w, "Clipboard is empty", http.StatusNotFound)
+			return
+		}
+
+		for _, rep := range entry.Content {
+			if rep.Type != mimeType {
+				continue
+			}
+
+			// Local content keeps its data; remote content above the inline limit is fetched from its origin
+			if rep.Data == nil && rep.Size > 0 && !entry.Local {
+				serveRemoteContent(keys, w, rep, entry.IP, entry.Port, entry.ClientID)
+				return
+			}
+
+			w.Header().Set("Content-Type", rep.Type)
+			w.Write(rep.Data)
+			return
+		}
+
+		http.Error(w, "Representation not found", http.StatusNotFound)
+	}
+}
+
+// serveRemoteContent streams a representation that was not inlined from its origin peer.
+func serveRemoteContent(keys *pairing.Keystore, w http.ResponseWriter, rep models.Representation, ip string, port int, peerID string) {
+	contentURL := netutil.URL("https", peerHost(keys, ip, peerID), port, "/content", url.Values{"token": {rep.Token}})
+	resp, err := fetchFromPeer(keys, contentURL, peerID)
+	if err != nil {
+		logger.Errorf("Failed to fetch %s content from %s: %v", rep.Type, contentURL, err)
+		http.Error(w, "Failed to fetch content from peer", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", rep.Type)
+	io.Copy(w, resp.Body)
+}
+
+// fetchFromPeer performs a signed GET to a paired peer and expects a 200 response.
+func fetchFromPeer(keys *pairing.Keystore, url, peerID string) (*http.Response, error) {
+	req, err := newPeerRequest(keys, url, peerID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := keys.HTTPClient(peerID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// HandleContent serves a representation copied on this machine to a paired peer.
+func HandleContent(clipboard *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "Missing token", http.StatusBadRequest)
+			return
+		}
+
+		// Only content of entries copied here and still in the history may be fetched
+		rep, ok := clipboard.LookupLocalContent(token)
+		if !ok {
+			logger.Warnf("Rejected content request for unknown token from %s", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", rep.Type)
+		w.Write(rep.Data)
+	}
+}
+
+// summarizeEntry drops representation data above the inline limit, for listings.
+func summarizeEntry(entry store.Entry) store.Entry {
+	if entry.Content == nil {
+		return entry
+	}
+	reps := make([]models.Representation, len(entry.Content))
+	for i, rep := range entry.Content {
+		if rep.Size > InlineContentLimit {
+			rep.Data = nil
+		}
+		reps[i] = rep
+	}
+	entry.Content = reps
+	return entry
+}
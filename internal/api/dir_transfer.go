@@ -0,0 +1,198 @@
+package api
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
+)
+
+// copyDir recursively copies the directory tree at src to dst.
+func copyDir(src, dst string, counter *transferCounter) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			if err := copyFile(path, target, counter); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		default:
+			// Symlinks, sockets, devices, etc. are not transferred
+			logger.Warnf("Skipping non-regular file: %s", path)
+			return nil
+		}
+	})
+}
+
+// dirSize returns the total size of the regular files below root.
+func dirSize(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// writeTar streams the directory tree at root as a tar archive.
+// Entry names are relative to root.
+func writeTar(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			logger.Warnf("Skipping non-regular file: %s", path)
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractTar unpacks a tar stream produced by writeTar under dst.
+func extractTar(r io.Reader, dst string, counter *transferCounter) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, filepath.FromSlash(header.Name))
+		// Refuse entries escaping the destination (e.g. "../../etc/passwd")
+		if target != dst && !strings.HasPrefix(target, dst+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid entry in archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, header.FileInfo().Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFileFrom(tr, target, header.FileInfo().Mode().Perm(), counter); err != nil {
+				return err
+			}
+		default:
+			logger.Warnf("Skipping unsupported archive entry: %s", header.Name)
+		}
+	}
+}
+
+func writeFileFrom(r io.Reader, dst string, perm os.FileMode, counter *transferCounter) error {
+	destFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(io.MultiWriter(destFile, counter), r)
+	return err
+}
+
+// serveDirAsTar writes the directory at dirPath to the response as a tar stream.
+func serveDirAsTar(w http.ResponseWriter, dirPath string) {
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(dirPath)+".tar"))
+
+	// Headers are already sent once streaming starts, so errors can only be logged
+	if err := writeTar(w, dirPath); err != nil {
+		logger.Errorf("Failed to stream directory %s: %v", dirPath, err)
+	}
+}
+
+func downloadDir(keys *pairing.Keystore, url, peerID, dst string, counter *transferCounter) error {
+	resp, err := fetchFromPeer(keys, url, peerID)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return extractTar(resp.Body, dst, counter)
+}
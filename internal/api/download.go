@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/retry"
+)
+
+const (
+	// A download is tried downloadAttempts times, waiting from downloadRetryMin
+	// doubling up to downloadRetryMax in between
+	downloadAttempts = 5
+	downloadRetryMin = 1 * time.Second
+	downloadRetryMax = 30 * time.Second
+
+	partSuffix = ".part"
+	// metaSuffix names the file next to a ".part" recording what it is a prefix of
+	metaSuffix = ".part.meta"
+)
+
+// partMeta identifies the source of a partial download. A ".part" without it, or
+// with another source, is never resumed.
+type partMeta struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"` // Last-Modified of the source
+}
+
+// permanentError marks download failures that retrying cannot fix (e.g. 404).
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+// downloadFile downloads url from the paired peer peerID to dst. Data is written to a ".part"
+// file next to dst which is resumed with a conditional Range request after a failure, also by
+// a later paste, and renamed once complete.
+func downloadFile(keys *pairing.Keystore, url, peerID, dst string, counter *transferCounter) error {
+	partPath := dst + partSuffix
+	metaPath := dst + metaSuffix
+	backoff := retry.NewBackoff(downloadRetryMin, downloadRetryMax)
+	// Last-Modified of the source, guards resumes against a changed source
+	validator := resumableValidator(partPath, metaPath, url)
+
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		validator, err = downloadAttempt(keys, url, peerID, partPath, validator, counter)
+		if err == nil {
+			os.Remove(metaPath)
+			return os.Rename(partPath, dst)
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			os.Remove(partPath)
+			os.Remove(metaPath)
+			return err
+		}
+		saveMeta(metaPath, partMeta{URL: url, Validator: validator})
+
+		if attempt < downloadAttempts {
+			delay := backoff.Delay()
+			logger.Warnf("Download of %s failed (attempt %d/%d): %v. Retrying in %v...", url, attempt, downloadAttempts, err, delay)
+			time.Sleep(delay)
+		}
+	}
+	return err
+}
+
+// resumableValidator returns the validator of a ".part" left for url, or removes a
+// ".part" that cannot be resumed safely and returns "".
+func resumableValidator(partPath, metaPath, url string) string {
+	var meta partMeta
+	data, err := os.ReadFile(metaPath)
+	if err == nil && json.Unmarshal(data, &meta) == nil && meta.URL == url && meta.Validator != "" {
+		return meta.Validator
+	}
+	if _, err := os.Stat(partPath); err == nil {
+		logger.Warnf("Discarding %s: not known to be a prefix of %s", partPath, url)
+	}
+	os.Remove(partPath)
+	os.Remove(metaPath)
+	return ""
+}
+
+func saveMeta(metaPath string, meta partMeta) {
+	data, err := json.Marshal(meta)
+	if err == nil {
+		err = os.WriteFile(metaPath, data, 0644)
+	}
+	if err != nil {
+		logger.Errorf("Failed to save %s: %v", metaPath, err)
+	}
+}
+
+// downloadAttempt fetches the remainder of url into partPath and returns the source's validator.
+// Without a validator the partial data cannot be checked, so the download starts over.
+func downloadAttempt(keys *pairing.Keystore, url, peerID, partPath, validator string, counter *transferCounter) (string, error) {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil && validator != "" {
+		offset = info.Size()
+	}
+
+	req, err := newPeerRequest(keys, url, peerID)
+	if err != nil {
+		return validator, &permanentError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+
+	client, err := keys.HTTPClient(peerID)
+	if err != nil {
+		return validator, &permanentError{err}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return validator, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		log.Printf("Resuming download of %s at byte %d", url, offset)
+	case http.StatusOK:
+		// Full content: either a fresh download or the source changed since the partial was written
+		flags |= os.O_TRUNC
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is already complete or larger than the source; start over on the next attempt
+		if total, ok := rangeTotal(resp); ok && total == offset {
+			return validator, nil
+		}
+		os.Remove(partPath)
+		counter.reset(0)
+		return validator, fmt.Errorf("bad status: %s", resp.Status)
+	default:
+		err := fmt.Errorf("bad status: %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return validator, &permanentError{err}
+		}
+		return validator, err
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		validator = lastModified
+	}
+
+	partFile, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return validator, &permanentError{err}
+	}
+	defer partFile.Close()
+
+	counter.reset(offset)
+	if _, err := io.Copy(io.MultiWriter(partFile, counter), resp.Body); err != nil {
+		return validator, err
+	}
+	return validator, partFile.Close()
+}
+
+// rangeTotal parses the complete length from a "bytes */N" Content-Range header.
+func rangeTotal(resp *http.Response) (int64, bool) {
+	var total int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &total); err != nil {
+		return 0, false
+	}
+	return total, true
+}
+
+// newPeerRequest creates a GET request to a paired peer, signed with the key shared with it.
+func newPeerRequest(keys *pairing.Keystore, url, peerID string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := keys.SignRequest(req.Header, peerID, req.Method, req.URL.RequestURI()); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
@@ -9,37 +9,79 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 
 	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/jobs"
+	"example.com/web-service/internal/logger"
 	"example.com/web-service/internal/models"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/pairing"
 	"example.com/web-service/internal/store"
 	"example.com/web-service/internal/websocket"
 )
 
-// GetLocalIP returns the non-loopback local IP of the host
+// GetLocalIP returns the non-loopback local IP of the host, preferring the interface of the
+// default route and IPv4. On IPv6-only networks a global IPv6 address is returned.
 func GetLocalIP() string {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return ""
+	// Any address outside the local subnets is routed through the default interface; nothing is sent
+	for _, probe := range []string{"192.0.2.1", "2001:db8::1"} {
+		if ip := netutil.LocalIPFor(probe); ip != "" && !net.ParseIP(ip).IsLoopback() {
+			return ip
+		}
 	}
-	for _, address := range addrs {
-		// check the address type and if it is not a loopback the display it
-		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String()
-			}
+
+	var ipv6 string
+	for _, addr := range netutil.InterfaceAddrs() {
+		if addr.IPNet.IP.To4() != nil {
+			return addr.IPNet.IP.String()
+		}
+		// Link-local addresses need a zone that means nothing to peers
+		if ipv6 == "" && !addr.IPNet.IP.IsLinkLocalUnicast() {
+			ipv6 = addr.IPNet.IP.String()
 		}
 	}
-	return ""
+	return ipv6
 }
 
-func HandleCopyFileInfoToCloud(hub *websocket.Hub, manager *websocket.ClientManager) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		EnableCORS(w)
-		if r.Method == "OPTIONS" {
-			return
+// peerHost returns the host to reach an announced IP at. A link-local IPv6 address
+// gets the zone of the interface the origin peer was discovered on.
+func peerHost(keys *pairing.Keystore, ip, clientID string) string {
+	address, _ := keys.AddressOf(clientID)
+	return netutil.WithZone(ip, netutil.Zone(address))
+}
+
+// broadcastPerPeer sends a clipboard announcement to local clients and peers. Each peer is
+// told the IP of our interface that reaches it, so downloads work on multi-homed hosts.
+// All copies share one relay ID, so peers that hear it twice handle it once.
+func broadcastPerPeer(hub *websocket.Hub, manager *websocket.ClientManager, defaultIP string, build func(ip string) websocket.Message) {
+	var relay websocket.Relay
+	if hub != nil {
+		relay = hub.NewRelay()
+	}
+	message := func(localIP string) []byte {
+		if localIP == "" {
+			localIP = defaultIP
+		}
+		msg := build(localIP)
+		msg.Relay = relay
+		msgBytes, err := json.Marshal(msg)
+		if err != nil {
+			logger.Errorf("Error marshaling broadcast message: %v", err)
+			return nil
 		}
+		return msgBytes
+	}
+
+	if hub != nil {
+		hub.BroadcastPerPeer(message)
+	}
+	if manager != nil {
+		manager.BroadcastPerPeer(message)
+	}
+}
+
+func HandleCopyFileInfoToCloud(cfg *config.Config, clipboard *store.Store, hub *websocket.Hub, manager *websocket.ClientManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -64,35 +106,30 @@ func HandleCopyFileInfoToCloud(hub *websocket.Hub, manager *websocket.ClientMana
 			return
 		}
 
+		describeFiles(payload.Files)
+
 		log.Printf("Files to copy file info to cloud: %+v", payload.Files)
 
 		// Get local IP
 		localIP := GetLocalIP()
 
 		// Save files to memory with auto-increment index
-		currentIndex := store.StoreFiles(payload.Files, localIP, config.HttpPort)
+		entry := clipboard.StoreLocalFiles(payload.Files, localIP, cfg.HttpPort, cfg.ClientID)
 
 		// Broadcast to local clients and cloud servers
-		msg := websocket.Message{
-			Type: "copyFileInfoToCloud",
-			Data: models.CopyFileInfoData{
-				Files: payload.Files,
-				Index: currentIndex,
-				IP:    localIP,
-				Port:  config.HttpPort,
-			},
-		}
-		msgBytes, err := json.Marshal(msg)
-		if err == nil {
-			if hub != nil {
-				hub.Broadcast(msgBytes)
-			}
-			if manager != nil {
-				manager.Broadcast(msgBytes)
+		broadcastPerPeer(hub, manager, localIP, func(ip string) websocket.Message {
+			return websocket.Message{
+				Type: "copyFileInfoToCloud",
+				Data: models.CopyFileInfoData{
+					Files:    payload.Files,
+					Index:    entry.Index,
+					IP:       ip,
+					Port:     cfg.HttpPort,
+					ClientID: cfg.ClientID,
+					Clock:    entry.Clock,
+				},
 			}
-		} else {
-			log.Printf("Error marshaling broadcast message: %v", err)
-		}
+		})
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -102,139 +139,224 @@ func HandleCopyFileInfoToCloud(hub *websocket.Hub, manager *websocket.ClientMana
 	}
 }
 
-func HandleDownload(w http.ResponseWriter, r *http.Request) {
-	EnableCORS(w)
-	if r.Method == "OPTIONS" {
-		return
-	}
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func HandleDownload(clipboard *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	filePath := r.URL.Query().Get("path")
-	if filePath == "" {
-		http.Error(w, "Missing file path", http.StatusBadRequest)
-		return
-	}
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "Missing token", http.StatusBadRequest)
+			return
+		}
 
-	log.Printf("Received request: /download?path=%s", filePath)
+		// Only files of entries copied here and still in the history may be downloaded,
+		// so peers can paste older entries; pruning the history revokes their tokens
+		file, ok := clipboard.LookupLocalFile(token)
+		if !ok {
+			logger.Warnf("Rejected download for unknown token from %s", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		filePath := file.Path
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
+		log.Printf("Received request: /download?token=%s (%s)", token, filePath)
 
-	http.ServeFile(w, r, filePath)
-}
+		info, err := os.Stat(filePath)
+		if os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
 
-func HandlePasteFileFromCloud(w http.ResponseWriter, r *http.Request) {
-	EnableCORS(w)
-	if r.Method == "OPTIONS" {
-		return
-	}
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+		if err == nil && info.IsDir() {
+			serveDirAsTar(w, filePath)
+			return
+		}
 
-	var payload struct {
-		Path string `json:"path"`
+		http.ServeFile(w, r, filePath)
 	}
+}
 
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&payload); err != nil {
-		http.Error(w, "Invalid payload", http.StatusBadRequest)
-		return
-	}
+func HandlePasteFileFromCloud(cfg *config.Config, keys *pairing.Keystore, clipboard *store.Store, hub *websocket.Hub, registry *jobs.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	if payload.Path == "" {
-		http.Error(w, "Missing path", http.StatusBadRequest)
-		return
-	}
+		var payload struct {
+			Path     string `json:"path"`     // Defaults to the configured download root
+			Conflict string `json:"conflict"` // overwrite (default), skip, rename or fail
+			Index    int64  `json:"index"`    // History entry to paste; the current clipboard if omitted
+		}
 
-	// Check if destination directory exists and is a directory
-	info, err := os.Stat(payload.Path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "Destination path does not exist", http.StatusBadRequest)
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&payload); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
 			return
 		}
-		http.Error(w, fmt.Sprintf("Failed to access destination path: %v", err), http.StatusInternalServerError)
-		return
-	}
-	if !info.IsDir() {
-		http.Error(w, "Destination path is not a directory", http.StatusBadRequest)
-		return
+
+		if payload.Path == "" {
+			payload.Path = cfg.DownloadRoot
+		}
+		if payload.Path == "" {
+			http.Error(w, "Missing path", http.StatusBadRequest)
+			return
+		}
+
+		policy, ok := parseConflictPolicy(payload.Conflict)
+		if !ok {
+			http.Error(w, "Invalid conflict policy", http.StatusBadRequest)
+			return
+		}
+
+		// Check if destination directory exists and is a directory
+		info, err := os.Stat(payload.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "Destination path does not exist", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to access destination path: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !info.IsDir() {
+			http.Error(w, "Destination path is not a directory", http.StatusBadRequest)
+			return
+		}
+
+		var entry store.Entry
+		if payload.Index > 0 {
+			entry, ok = clipboard.Get(payload.Index)
+			if !ok {
+				http.Error(w, "History entry not found", http.StatusNotFound)
+				return
+			}
+		} else {
+			entry, ok = clipboard.Current()
+			if !ok {
+				http.Error(w, "Clipboard is empty", http.StatusNotFound)
+				return
+			}
+		}
+
+		log.Printf("PasteFileFromCloud: Dest=%s, Conflict=%s, Index=%d, StoredIP=%s, Local=%t, FilesCount=%d (Async started)", payload.Path, policy, entry.Index, entry.IP, entry.Local, len(entry.Files))
+
+		job := registry.New(payload.Path, entry.Files)
+		go runPasteJob(keys, newPasteReporter(hub, job), entry, payload.Path, policy)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Paste operation started",
+			"jobId":   job.ID,
+		})
 	}
+}
 
-	files, storedIP, storedPort := store.GetFiles()
-	localIP := GetLocalIP()
+// runPasteJob copies or downloads every file of the job into destDir and records the results.
+func runPasteJob(keys *pairing.Keystore, reporter *pasteReporter, entry store.Entry, destDir string, policy ConflictPolicy) {
+	job := reporter.job
+	job.Start()
+
+	for i, file := range entry.Files {
+		destPath, conflict, err := resolveDest(destDir, file.Name, policy)
+		if err != nil {
+			logger.Errorf("Failed to paste %s: %v", file.Name, err)
+			job.StartFile(i, destPath, "")
+			reporter.fileDone(i, err)
+			continue
+		}
+		if conflict == "skipped" {
+			log.Printf("Skipping %s: destination already exists", destPath)
+			reporter.fileSkipped(i, destPath)
+			continue
+		}
+		job.StartFile(i, destPath, conflict)
 
-	log.Printf("PasteFileFromCloud: Dest=%s, StoredIP=%s, LocalIP=%s, FilesCount=%d (Async started)", payload.Path, storedIP, localIP, len(files))
+		counter := &transferCounter{progress: func(written int64) {
+			reporter.progress(i, written)
+		}}
 
-	go func() {
-		var successCount int
-		var failureCount int
+		if entry.Local {
+			// Local copy
+			if file.IsDir {
+				err = copyDir(file.Path, destPath, counter)
+			} else {
+				err = copyFile(file.Path, destPath, counter)
+			}
+			if err != nil {
+				logger.Errorf("Failed to copy local file %s: %v", file.Path, err)
+			}
+		} else {
+			// Remote download
+			downloadURL := netutil.URL("https", peerHost(keys, entry.IP, entry.ClientID), entry.Port, "/download", url.Values{"token": {file.Token}})
+			if file.IsDir {
+				err = downloadDir(keys, downloadURL, entry.ClientID, destPath, counter)
+			} else {
+				err = downloadFile(keys, downloadURL, entry.ClientID, destPath, counter)
+			}
+			if err != nil {
+				logger.Errorf("Failed to download remote file %s: %v", downloadURL, err)
+			}
+		}
 
-		for _, file := range files {
-			destPath := filepath.Join(payload.Path, file.Name)
+		reporter.fileDone(i, err)
+	}
 
-			if storedIP == localIP {
-				// Local copy
-				if err := copyFile(file.Path, destPath); err != nil {
-					log.Printf("Failed to copy local file %s: %v", file.Path, err)
-					failureCount++
-				} else {
-					successCount++
+	reporter.completed()
+}
+
+// describeFiles marks directories and fills in missing sizes from the local file system.
+func describeFiles(files []models.FileData) {
+	for i := range files {
+		info, err := os.Stat(files[i].Path)
+		if err != nil {
+			logger.Errorf("Failed to stat %s: %v", files[i].Path, err)
+			continue
+		}
+		files[i].IsDir = info.IsDir()
+		if files[i].Size == 0 {
+			if info.IsDir() {
+				if size, err := dirSize(files[i].Path); err == nil {
+					files[i].Size = size
 				}
 			} else {
-				// Remote download
-				downloadURL := fmt.Sprintf("http://%s:%d/download?path=%s", storedIP, storedPort, url.QueryEscape(file.Path))
-				if err := downloadFile(downloadURL, destPath); err != nil {
-					log.Printf("Failed to download remote file %s: %v", downloadURL, err)
-					failureCount++
-				} else {
-					successCount++
-				}
+				files[i].Size = info.Size()
 			}
 		}
-		log.Printf("Paste operation finished: success=%d, failure=%d", successCount, failureCount)
-	}()
+	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Paste operation started",
-	})
+// transferCounter counts the bytes written for one pasted entry and reports the running total.
+type transferCounter struct {
+	written  int64
+	progress func(written int64)
 }
 
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
+func (c *transferCounter) Write(p []byte) (int, error) {
+	c.written += int64(len(p))
+	if c.progress != nil {
+		c.progress(c.written)
 	}
-	defer sourceFile.Close()
+	return len(p), nil
+}
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
+// reset restarts the running total at written, e.g. when resuming a partial download.
+func (c *transferCounter) reset(written int64) {
+	c.written = written
+	if c.progress != nil {
+		c.progress(c.written)
 	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
-	return err
 }
 
-func downloadFile(url, dst string) error {
-	resp, err := http.Get(url)
+func copyFile(src, dst string, counter *transferCounter) error {
+	sourceFile, err := os.Open(src)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
+	defer sourceFile.Close()
 
 	destFile, err := os.Create(dst)
 	if err != nil {
@@ -242,6 +364,6 @@ func downloadFile(url, dst string) error {
 	}
 	defer destFile.Close()
 
-	_, err = io.Copy(destFile, resp.Body)
+	_, err = io.Copy(io.MultiWriter(destFile, counter), sourceFile)
 	return err
 }
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/jobs"
+	"example.com/web-service/internal/store"
+)
+
+func TestPasteEmptyClipboard(t *testing.T) {
+	cfg := config.Default()
+	// The history is written in the background, which t.TempDir's cleanup could trip over
+	dataDir, err := os.MkdirTemp("", "pasteflow-paste")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+	cfg.DataDir = dataDir
+	handler := HandlePasteFileFromCloud(cfg, nil, store.New(cfg), nil, jobs.NewRegistry())
+
+	body, err := json.Marshal(map[string]string{"path": t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/api/pasteFileFromCloud", bytes.NewReader(body)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("pasting an empty clipboard returned %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"example.com/web-service/internal/store"
+)
+
+// HandleGetHistory lists the clipboard history, newest first.
+func HandleGetHistory(clipboard *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		entries := clipboard.History()
+		for i := range entries {
+			entries[i] = summarizeEntry(entries[i])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
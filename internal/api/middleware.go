@@ -1,9 +1,59 @@
 package api
 
-import "net/http"
+import (
+	"net/http"
+	"slices"
 
-func EnableCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/pairing"
+)
+
+// LocalOnly rejects requests that do not come from this machine, for endpoints
+// only the local agent may call (e.g. showing a pairing PIN). Any web page open in a
+// browser runs on this machine too, so requests carrying an Origin are refused unless
+// it is one of cfg.AllowedOrigins; only those get CORS headers, and their preflight is
+// answered here.
+func LocalOnly(cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !netutil.IsLoopback(r.RemoteAddr) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !slices.Contains(cfg.AllowedOrigins, origin) {
+				logger.Warnf("Rejected %s from origin %s", r.URL.Path, origin)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			allowOrigin(w, origin)
+			if r.Method == "OPTIONS" {
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func allowOrigin(w http.ResponseWriter, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding")
+}
+
+// RequirePairedPeer rejects requests that are not signed by a paired peer.
+// Unsigned requests from this machine are trusted.
+func RequirePairedPeer(keys *pairing.Keystore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !netutil.IsLoopback(r.RemoteAddr) || r.Header.Get(pairing.HeaderSignature) != "" {
+			if _, err := keys.VerifyRequest(r); err != nil {
+				logger.Warnf("Rejected %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
 }
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/web-service/internal/config"
+)
+
+func TestLocalOnly(t *testing.T) {
+	cfg := config.Default()
+	cfg.AllowedOrigins = config.StringList{"http://localhost:3000"}
+	handler := LocalOnly(cfg, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pin"))
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		remoteAddr string
+		origin     string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{"local agent", "POST", "127.0.0.1:50000", "", http.StatusOK, "pin", ""},
+		{"other machine", "POST", "192.0.2.7:50000", "", http.StatusForbidden, "", ""},
+		{"web page", "POST", "127.0.0.1:50000", "https://evil.example", http.StatusForbidden, "", ""},
+		{"web page preflight", "OPTIONS", "127.0.0.1:50000", "https://evil.example", http.StatusForbidden, "", ""},
+		{"allowed origin", "POST", "[::1]:50000", "http://localhost:3000", http.StatusOK, "pin", "http://localhost:3000"},
+		{"allowed preflight", "OPTIONS", "127.0.0.1:50000", "http://localhost:3000", http.StatusOK, "", "http://localhost:3000"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/api/pairing/start", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.wantBody)
+		}
+		if allow := w.Header().Get("Access-Control-Allow-Origin"); allow != tt.wantAllow {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want %q", tt.name, allow, tt.wantAllow)
+		}
+	}
+}
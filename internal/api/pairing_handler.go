@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/websocket"
+)
+
+// HandlePairingStart creates a one-time PIN for the local agent to display.
+func HandlePairingStart(keys *pairing.Keystore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		pin, expires := keys.StartPairing()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pin":       pin,
+			"expiresAt": expires,
+		})
+	}
+}
+
+// HandlePairingJoin pairs with a discovered peer using the PIN shown on it, then connects to it.
+func HandlePairingJoin(keys *pairing.Keystore, manager *websocket.ClientManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var payload struct {
+			ClientID string `json:"clientId"`
+			Address  string `json:"address"` // host:port, if the peer was not discovered
+			Pin      string `json:"pin"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		address := payload.Address
+		if address == "" && payload.ClientID != "" {
+			address, _ = keys.AddressOf(payload.ClientID)
+		}
+		if address == "" || payload.Pin == "" {
+			http.Error(w, "Missing peer or pin", http.StatusBadRequest)
+			return
+		}
+
+		clientID, err := keys.Join(address, payload.Pin)
+		if err != nil {
+			logger.Warnf("Pairing with %s failed: %v", address, err)
+			http.Error(w, "Pairing failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if payload.ClientID != "" && clientID != payload.ClientID {
+			logger.Warnf("Pairing: Peer at %s answered as %s instead of %s", address, clientID, payload.ClientID)
+		}
+
+		keys.NoteDiscovered(clientID, address, pairing.DeviceInfo{}, 0)
+		if manager != nil {
+			manager.ConnectToCloud(address, clientID)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":  "Paired successfully",
+			"clientId": clientID,
+		})
+	}
+}
+
+// HandlePairingPeers lists discovered and paired peers.
+func HandlePairingPeers(keys *pairing.Keystore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys.Peers())
+	}
+}
+
+// HandlePair answers a pairing request from a peer the user entered our PIN on with our
+// share of the key exchange. The peer is paired once it confirms on /pair/confirm.
+func HandlePair(keys *pairing.Keystore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req pairing.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ClientID == "" {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := keys.Respond(req)
+		if err != nil {
+			logger.Warnf("Pairing request from %s (ClientID: %s) rejected: %v", r.RemoteAddr, req.ClientID, err)
+			status := http.StatusForbidden
+			if errors.Is(err, pairing.ErrNoPairing) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// HandlePairConfirm completes a pairing once the peer proves it derived the same key.
+func HandlePairConfirm(keys *pairing.Keystore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var c pairing.Confirmation
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil || c.ClientID == "" {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := keys.Confirm(c); err != nil {
+			logger.Warnf("Pairing confirmation from %s (ClientID: %s) rejected: %v", r.RemoteAddr, c.ClientID, err)
+			status := http.StatusForbidden
+			if errors.Is(err, pairing.ErrNoPairing) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Paired successfully"})
+	}
+}
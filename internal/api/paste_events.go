@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"example.com/web-service/internal/jobs"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/models"
+	"example.com/web-service/internal/websocket"
+)
+
+const (
+	// ProgressInterval is the minimum time between two pasteProgress messages of a job
+	ProgressInterval = 250 * time.Millisecond
+)
+
+// pasteReporter pushes the progress of a paste job to local WebSocket clients.
+type pasteReporter struct {
+	hub      *websocket.Hub
+	job      *jobs.Job
+	mu       sync.Mutex
+	lastSent time.Time
+}
+
+func newPasteReporter(hub *websocket.Hub, job *jobs.Job) *pasteReporter {
+	return &pasteReporter{hub: hub, job: job}
+}
+
+// progress records the bytes written for entry i and sends a throttled pasteProgress message.
+func (p *pasteReporter) progress(i int, written int64) {
+	p.job.SetBytes(i, written)
+
+	p.mu.Lock()
+	if time.Since(p.lastSent) < ProgressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.lastSent = time.Now()
+	p.mu.Unlock()
+
+	status := p.job.Snapshot()
+	file := status.Files[i]
+	p.send("pasteProgress", models.PasteProgressData{
+		JobID:            status.ID,
+		Destination:      status.Destination,
+		FileIndex:        i,
+		Name:             file.Name,
+		BytesTransferred: file.BytesTransferred,
+		Size:             file.Size,
+		TotalTransferred: status.Transferred,
+		TotalSize:        status.TotalSize,
+	})
+}
+
+// fileDone records the outcome of entry i and sends pasteFileDone.
+func (p *pasteReporter) fileDone(i int, err error) {
+	p.job.FinishFile(i, err)
+	p.sendFileDone(i)
+}
+
+// fileSkipped records that entry i was skipped because dest exists and sends pasteFileDone.
+func (p *pasteReporter) fileSkipped(i int, dest string) {
+	p.job.SkipFile(i, dest)
+	p.sendFileDone(i)
+}
+
+func (p *pasteReporter) sendFileDone(i int) {
+	status := p.job.Snapshot()
+	file := status.Files[i]
+	p.send("pasteFileDone", models.PasteFileDoneData{
+		JobID:            status.ID,
+		Destination:      status.Destination,
+		FileIndex:        i,
+		Name:             file.Name,
+		Dest:             file.Dest,
+		BytesTransferred: file.BytesTransferred,
+		Size:             file.Size,
+		Success:          file.State == jobs.StateCompleted,
+		Skipped:          file.State == jobs.StateSkipped,
+		Conflict:         file.Conflict,
+		Error:            file.Error,
+	})
+}
+
+// completed finishes the job and sends pasteCompleted.
+func (p *pasteReporter) completed() {
+	p.job.Finish()
+
+	status := p.job.Snapshot()
+	p.send("pasteCompleted", models.PasteCompletedData{
+		JobID:            status.ID,
+		Destination:      status.Destination,
+		State:            string(status.State),
+		Success:          status.Success,
+		Failure:          status.Failure,
+		Skipped:          status.Skipped,
+		TotalTransferred: status.Transferred,
+		TotalSize:        status.TotalSize,
+	})
+}
+
+func (p *pasteReporter) send(msgType string, data interface{}) {
+	if p.hub == nil {
+		return
+	}
+	msgBytes, err := json.Marshal(websocket.Message{Type: msgType, Data: data})
+	if err != nil {
+		logger.Errorf("Error marshaling %s message: %v", msgType, err)
+		return
+	}
+	p.hub.BroadcastLocal(msgBytes)
+}
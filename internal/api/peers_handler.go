@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/websocket"
+)
+
+// HandlePeers lists the peers connected to us and those we connect to (GET),
+// or adds a static peer by host:port (POST).
+func HandlePeers(keys *pairing.Keystore, manager *websocket.ClientManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(manager.Peers())
+		case "POST":
+			addPeer(keys, manager, w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func addPeer(keys *pairing.Keystore, manager *websocket.ClientManager, w http.ResponseWriter, r *http.Request) {
+	var peer config.StaticPeer
+	if err := json.NewDecoder(r.Body).Decode(&peer); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(peer.Address); err != nil {
+		http.Error(w, "Invalid address, expected host:port", http.StatusBadRequest)
+		return
+	}
+
+	peer, err := manager.AddStaticPeer(peer)
+	if err != nil {
+		logger.Warnf("Failed to add peer %s: %v", peer.Address, err)
+		http.Error(w, "Peer unreachable: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("Added static peer %s (ClientID: %s)", peer.Address, peer.ClientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"clientId": peer.ClientID,
+		"address":  peer.Address,
+		"paired":   keys.IsPaired(peer.ClientID),
+	})
+}
+
+// HandleDeletePeer removes a static peer added through the API and disconnects from it.
+func HandleDeletePeer(manager *websocket.ClientManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		found, err := manager.RemoveStaticPeer(r.PathValue("id"))
+		if errors.Is(err, websocket.ErrConfiguredPeer) {
+			http.Error(w, "Peer is defined in the configuration", http.StatusConflict)
+			return
+		}
+		if !found {
+			http.Error(w, "Static peer not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/store"
+	"example.com/web-service/internal/websocket"
+)
+
+// DiskSpace is the result of the diskSpace method.
+type DiskSpace struct {
+	Path  string `json:"path"`
+	Free  uint64 `json:"free"` // Bytes available to us
+	Total uint64 `json:"total"`
+}
+
+// RegisterMethods sets the methods peers can call:
+//
+//	clipboard            the current clipboard entry, null if empty
+//	hasFile {token}      whether a file we announced can still be downloaded
+//	diskSpace {path?}    free space at path, the download root by default
+func RegisterMethods(cfg *config.Config, clipboard *store.Store, hub *websocket.Hub) {
+	hub.RegisterMethod("clipboard", func(s *websocket.Session, params json.RawMessage) (interface{}, error) {
+		entry, ok := clipboard.Current()
+		if !ok {
+			return nil, nil
+		}
+		return summarizeEntry(entry), nil
+	})
+
+	hub.RegisterMethod("hasFile", func(s *websocket.Session, params json.RawMessage) (interface{}, error) {
+		var p struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil || p.Token == "" {
+			return nil, errors.New("missing token")
+		}
+		file, ok := clipboard.LookupLocalFile(p.Token)
+		if ok {
+			_, err := os.Stat(file.Path)
+			ok = err == nil
+		}
+		return map[string]bool{"available": ok}, nil
+	})
+
+	hub.RegisterMethod("diskSpace", func(s *websocket.Session, params json.RawMessage) (interface{}, error) {
+		var p struct {
+			Path string `json:"path"`
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, err
+			}
+		}
+		if p.Path == "" {
+			p.Path = cfg.DownloadRoot
+		}
+		if p.Path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			p.Path = home
+		}
+		free, total, err := diskSpace(p.Path)
+		if err != nil {
+			return nil, err
+		}
+		return DiskSpace{Path: p.Path, Free: free, Total: total}, nil
+	})
+}
+
+// HandlePeerCall calls a method on a connected peer for the local agent and returns its result.
+func HandlePeerCall(manager *websocket.ClientManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var payload struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Method == "" {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		var params interface{}
+		if len(payload.Params) > 0 {
+			params = payload.Params
+		}
+		peerID := r.PathValue("id")
+		result, err := manager.Call(r.Context(), peerID, payload.Method, params)
+		if err != nil {
+			logger.Warnf("Call %s on %s failed: %v", payload.Method, peerID, err)
+			var remote *websocket.RemoteError
+			switch {
+			case errors.Is(err, websocket.ErrPeerNotConnected):
+				http.Error(w, "Peer not connected", http.StatusNotFound)
+			case errors.Is(err, websocket.ErrUnsupported):
+				http.Error(w, "Peer does not support requests", http.StatusNotImplemented)
+			case errors.Is(err, context.DeadlineExceeded):
+				http.Error(w, "Peer did not answer", http.StatusGatewayTimeout)
+			case errors.As(err, &remote):
+				http.Error(w, remote.Message, http.StatusBadGateway)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result)
+	}
+}
@@ -2,17 +2,15 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
+
+	"example.com/web-service/internal/logger"
 )
 
 func HandleUDPSend(w http.ResponseWriter, r *http.Request) {
-	EnableCORS(w)
-	if r.Method == "OPTIONS" {
-		return
-	}
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -35,16 +33,16 @@ func HandleUDPSend(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send UDP
-	remoteAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", reqBody.Host, reqBody.Port))
+	remoteAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(reqBody.Host, strconv.Itoa(reqBody.Port)))
 	if err != nil {
-		log.Printf("UDP resolve error: %v", err)
+		logger.Errorf("UDP resolve error: %v", err)
 		http.Error(w, "Invalid address", http.StatusInternalServerError)
 		return
 	}
 
 	conn, err := net.DialUDP("udp", nil, remoteAddr)
 	if err != nil {
-		log.Printf("UDP manual send error: %v", err)
+		logger.Errorf("UDP manual send error: %v", err)
 		http.Error(w, "Failed to send UDP message", http.StatusInternalServerError)
 		return
 	}
@@ -52,7 +50,7 @@ func HandleUDPSend(w http.ResponseWriter, r *http.Request) {
 
 	_, err = conn.Write([]byte(reqBody.Message))
 	if err != nil {
-		log.Printf("UDP manual send error: %v", err)
+		logger.Errorf("UDP manual send error: %v", err)
 		http.Error(w, "Failed to send UDP message", http.StatusInternalServerError)
 		return
 	}
@@ -1,12 +1,393 @@
 package config
 
-import "github.com/google/uuid"
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
-const (
-	HttpPort = 8000
-	UdpPort  = 8001
+	"example.com/web-service/internal/logger"
+	"github.com/google/uuid"
 )
 
-var (
-	ClientID = uuid.New().String()
+const (
+	DefaultHttpPort = 8000
+	DefaultUdpPort  = 8001
+
+	// EnvPrefix prefixes the environment variable of every flag, e.g. PASTEFLOW_HTTP_PORT for -http-port
+	EnvPrefix = "PASTEFLOW_"
+
+	clientIDFile = "client_id"
 )
+
+// Config holds the settings of one agent instance. Values are taken from the
+// defaults, then the config file, then environment variables, then command-line flags.
+type Config struct {
+	HttpPort     int    `json:"httpPort"`
+	UdpPort      int    `json:"udpPort"`
+	BindAddress  string `json:"bindAddress"` // Empty listens on all interfaces
+	DeviceName   string `json:"deviceName"`  // Shown to peers instead of the client ID
+	Platform     string `json:"platform"`
+	DownloadRoot string `json:"downloadRoot"` // Default paste destination
+	DataDir      string `json:"dataDir"`      // Persistent state: paired peers, certificate, history
+	LogLevel     string `json:"logLevel"`     // debug, info, warn or error
+
+	// AllowedOrigins are the web origins that may call the local API from a browser,
+	// e.g. "http://localhost:3000". Other pages on this machine are refused.
+	AllowedOrigins StringList `json:"allowedOrigins"`
+
+	// Discovery lists the enabled discovery backends: broadcast and mdns
+	Discovery StringList `json:"discovery"`
+	// Peers are always connected to, for networks discovery cannot cross
+	Peers StaticPeers `json:"peers"`
+
+	// Presence announcements start every BroadcastInterval. After BroadcastCount of them
+	// without hearing a new peer the interval doubles up to BroadcastMaxInterval.
+	BroadcastInterval    Duration `json:"broadcastInterval"`
+	BroadcastCount       int      `json:"broadcastCount"`
+	BroadcastMaxInterval Duration `json:"broadcastMaxInterval"`
+	// PeerTTL is how long a peer is considered present after its last announcement
+	PeerTTL Duration `json:"peerTtl"`
+
+	// Reconnection attempts start ReconnectInterval apart, doubling up to ReconnectMaxInterval.
+	// After ReconnectCount failures a discovered peer is parked until discovery sees it again.
+	ReconnectCount       int      `json:"reconnectCount"`
+	ReconnectInterval    Duration `json:"reconnectInterval"`
+	ReconnectMaxInterval Duration `json:"reconnectMaxInterval"`
+
+	// MaxMessageSize limits a WebSocket message we accept. Larger announcements are
+	// fragmented by peers that support it.
+	MaxMessageSize int64 `json:"maxMessageSize"`
+	// RelayHops is how many times a clipboard announcement is forwarded between peers
+	RelayHops int `json:"relayHops"`
+
+	HistoryMaxCount int      `json:"historyMaxCount"`
+	HistoryMaxAge   Duration `json:"historyMaxAge"`
+
+	// ClientID identifies this instance to peers. It is persisted in the data directory.
+	ClientID string `json:"-"`
+}
+
+// Duration is a time.Duration written as a string such as "5s" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// StringList is a list given as a comma-separated flag value.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// StaticPeer is a peer configured by address. Without a client ID it is asked for it.
+type StaticPeer struct {
+	ClientID string `json:"clientId,omitempty"`
+	Address  string `json:"address"` // host:port of its HTTP/WS server
+}
+
+// StaticPeers is given as a comma-separated flag value of "host:port" or "clientId@host:port".
+type StaticPeers []StaticPeer
+
+func (p *StaticPeers) String() string {
+	items := make([]string, len(*p))
+	for i, peer := range *p {
+		items[i] = peer.Address
+		if peer.ClientID != "" {
+			items[i] = peer.ClientID + "@" + peer.Address
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (p *StaticPeers) Set(value string) error {
+	var list StringList
+	list.Set(value)
+	*p = nil
+	for _, item := range list {
+		peer := StaticPeer{Address: item}
+		if id, address, ok := strings.Cut(item, "@"); ok {
+			peer = StaticPeer{ClientID: id, Address: address}
+		}
+		if _, _, err := net.SplitHostPort(peer.Address); err != nil {
+			return fmt.Errorf("invalid peer address %q: %w", peer.Address, err)
+		}
+		*p = append(*p, peer)
+	}
+	return nil
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	deviceName, _ := os.Hostname()
+	return &Config{
+		HttpPort:             DefaultHttpPort,
+		UdpPort:              DefaultUdpPort,
+		DeviceName:           deviceName,
+		Platform:             defaultPlatform(),
+		DataDir:              defaultDataDir(),
+		LogLevel:             "info",
+		Discovery:            StringList{"broadcast", "mdns"},
+		BroadcastInterval:    Duration{1 * time.Second},
+		BroadcastCount:       3,
+		BroadcastMaxInterval: Duration{30 * time.Second},
+		PeerTTL:              Duration{90 * time.Second},
+		ReconnectCount:       3,
+		ReconnectInterval:    Duration{5 * time.Second},
+		ReconnectMaxInterval: Duration{2 * time.Minute},
+		MaxMessageSize:       1 << 20,
+		RelayHops:            3,
+		HistoryMaxCount:      50,
+		HistoryMaxAge:        Duration{7 * 24 * time.Hour},
+	}
+}
+
+func defaultPlatform() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "macOS"
+	case "windows":
+		return "Windows"
+	case "linux":
+		return "Linux"
+	default:
+		return runtime.GOOS
+	}
+}
+
+func defaultDataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "PasteFlow")
+}
+
+// Load builds the configuration from the config file, environment and command-line args.
+// The config file is given by -config or PASTEFLOW_CONFIG, or defaults to config.json in the data directory.
+func Load(args []string) (*Config, error) {
+	// First pass only finds the config file and data directory
+	probe := Default()
+	configPath := os.Getenv(EnvPrefix + "CONFIG")
+	probeFlags := newFlagSet(probe, &configPath)
+	if err := applyEnv(probeFlags); err != nil {
+		return nil, err
+	}
+	if err := probeFlags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	explicit := configPath != ""
+	if !explicit {
+		configPath = filepath.Join(probe.DataDir, "config.json")
+	}
+	if err := cfg.readFile(configPath, explicit); err != nil {
+		return nil, err
+	}
+
+	fs := newFlagSet(cfg, &configPath)
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %w", cfg.DataDir, err)
+	}
+
+	clientID, err := loadClientID(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientID = clientID
+	return cfg, nil
+}
+
+// Validate rejects settings the agent cannot run with, such as a zero interval
+// that would make announcements or reconnects spin.
+func (c *Config) Validate() error {
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	for _, port := range []struct {
+		name  string
+		value int
+	}{{"http-port", c.HttpPort}, {"udp-port", c.UdpPort}} {
+		if port.value < 0 || port.value > 65535 {
+			return fmt.Errorf("invalid %s %d: must be between 0 and 65535", port.name, port.value)
+		}
+	}
+
+	for _, interval := range []struct {
+		name  string
+		value Duration
+	}{
+		{"broadcast-interval", c.BroadcastInterval},
+		{"peer-ttl", c.PeerTTL},
+		{"reconnect-interval", c.ReconnectInterval},
+	} {
+		if interval.value.Duration <= 0 {
+			return fmt.Errorf("invalid %s %v: must be positive", interval.name, interval.value.Duration)
+		}
+	}
+
+	for _, duration := range []struct {
+		name  string
+		value Duration
+	}{
+		{"broadcast-max-interval", c.BroadcastMaxInterval},
+		{"reconnect-max-interval", c.ReconnectMaxInterval},
+		{"history-max-age", c.HistoryMaxAge},
+	} {
+		if duration.value.Duration < 0 {
+			return fmt.Errorf("invalid %s %v: must not be negative", duration.name, duration.value.Duration)
+		}
+	}
+
+	for _, count := range []struct {
+		name  string
+		value int64
+	}{
+		{"broadcast-count", int64(c.BroadcastCount)},
+		{"reconnect-count", int64(c.ReconnectCount)},
+		{"max-message-size", c.MaxMessageSize},
+		{"relay-hops", int64(c.RelayHops)},
+		{"history-max-count", int64(c.HistoryMaxCount)},
+	} {
+		if count.value < 0 {
+			return fmt.Errorf("invalid %s %d: must not be negative", count.name, count.value)
+		}
+	}
+
+	for _, peer := range c.Peers {
+		if _, _, err := net.SplitHostPort(peer.Address); err != nil {
+			return fmt.Errorf("invalid peer address %q: %w", peer.Address, err)
+		}
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		// An origin is compared as sent by browsers: scheme://host[:port]
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("invalid allowed origin %q: must be scheme://host[:port]", origin)
+		}
+	}
+	return nil
+}
+
+// loadClientID returns the client ID stored in dataDir, creating it on first launch
+// so peers recognize this device across restarts.
+func loadClientID(dataDir string) (string, error) {
+	path := filepath.Join(dataDir, clientIDFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id, err := uuid.Parse(strings.TrimSpace(string(data))); err == nil {
+			return id.String(), nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read client ID: %w", err)
+	}
+
+	id := uuid.New().String()
+	if err := os.WriteFile(path, []byte(id+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to save client ID: %w", err)
+	}
+	return id, nil
+}
+
+func (c *Config) readFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func newFlagSet(c *Config, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("local-server", flag.ContinueOnError)
+	fs.StringVar(configPath, "config", *configPath, "path of the JSON config file")
+	fs.IntVar(&c.HttpPort, "http-port", c.HttpPort, "HTTP/WebSocket port")
+	fs.IntVar(&c.UdpPort, "udp-port", c.UdpPort, "UDP discovery port")
+	fs.StringVar(&c.BindAddress, "bind", c.BindAddress, "address to listen on (all interfaces if empty)")
+	fs.StringVar(&c.DeviceName, "device-name", c.DeviceName, "name shown to other devices")
+	fs.StringVar(&c.Platform, "platform", c.Platform, "platform shown to other devices")
+	fs.StringVar(&c.DownloadRoot, "download-root", c.DownloadRoot, "default paste destination")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory for persistent state")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level: debug, info, warn or error")
+	fs.Var(&c.AllowedOrigins, "allowed-origins", "comma-separated web origins allowed to call the local API from a browser")
+	fs.Var(&c.Discovery, "discovery", "comma-separated discovery backends: broadcast, mdns")
+	fs.Var(&c.Peers, "peers", "comma-separated static peers: host:port or clientId@host:port")
+	fs.DurationVar(&c.BroadcastInterval.Duration, "broadcast-interval", c.BroadcastInterval.Duration, "initial interval between presence announcements")
+	fs.IntVar(&c.BroadcastCount, "broadcast-count", c.BroadcastCount, "announcements at the initial interval before backing off")
+	fs.DurationVar(&c.BroadcastMaxInterval.Duration, "broadcast-max-interval", c.BroadcastMaxInterval.Duration, "maximum interval between presence announcements")
+	fs.DurationVar(&c.PeerTTL.Duration, "peer-ttl", c.PeerTTL.Duration, "time after its last announcement until a peer is considered gone")
+	fs.IntVar(&c.ReconnectCount, "reconnect-count", c.ReconnectCount, "failed connection attempts before a discovered peer is parked until seen again (0 retries forever)")
+	fs.DurationVar(&c.ReconnectInterval.Duration, "reconnect-interval", c.ReconnectInterval.Duration, "initial delay between connection attempts")
+	fs.DurationVar(&c.ReconnectMaxInterval.Duration, "reconnect-max-interval", c.ReconnectMaxInterval.Duration, "maximum delay between connection attempts")
+	fs.Int64Var(&c.MaxMessageSize, "max-message-size", c.MaxMessageSize, "largest WebSocket message accepted, in bytes")
+	fs.IntVar(&c.RelayHops, "relay-hops", c.RelayHops, "times a clipboard announcement is forwarded between peers (0 disables relaying)")
+	fs.IntVar(&c.HistoryMaxCount, "history-max-count", c.HistoryMaxCount, "clipboard history entries to keep (0 for unlimited)")
+	fs.DurationVar(&c.HistoryMaxAge.Duration, "history-max-age", c.HistoryMaxAge.Duration, "maximum age of clipboard history entries (0 for unlimited)")
+	return fs
+}
+
+// applyEnv sets every flag that has a matching PASTEFLOW_* environment variable.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && err == nil {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s: %w", name, setErr)
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/retry"
+)
+
+const (
+	BroadcastAddr = "255.255.255.255"
+	// AllNodesAddr is the IPv6 link-local all-nodes group, the IPv6 counterpart of a broadcast
+	AllNodesAddr = "ff02::1"
+)
+
+type DiscoveryMessage struct {
+	ClientID   string `json:"clientId"`
+	DeviceName string `json:"deviceName,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	Port       int    `json:"port"`
+	IP         string `json:"ip"`            // Optional, receiver can use remote addr
+	TTL        int    `json:"ttl,omitempty"` // Seconds until the sender is considered gone without a new announcement
+}
+
+// broadcastBackend announces this device with UDP broadcasts to the UDP port, on the
+// directed broadcast address of every interface so multi-homed hosts reach all their
+// networks, and to the IPv6 all-nodes group of every interface for IPv6-only segments.
+// Announcements of others are received by server.StartUDP, which shares that port.
+type broadcastBackend struct{}
+
+func (b *broadcastBackend) Name() string {
+	return "broadcast"
+}
+
+func (b *broadcastBackend) Run(d *Discovery) error {
+	cfg := d.cfg
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	conn6, err := net.ListenUDP("udp6", nil)
+	if err != nil {
+		logger.Warnf("Discovery: IPv6 announcements disabled: %v", err)
+	} else {
+		defer conn6.Close()
+	}
+
+	msg := DiscoveryMessage{
+		ClientID:   cfg.ClientID,
+		DeviceName: cfg.DeviceName,
+		Platform:   cfg.Platform,
+		Port:       cfg.HttpPort, // Local Server's HTTP/WS port
+		TTL:        int(cfg.PeerTTL.Seconds()),
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	// Announce at the base interval first, then back off while the network stays quiet
+	interval := cfg.BroadcastInterval.Duration
+	sent := 0
+	for {
+		for _, addr := range broadcastAddrs(cfg.UdpPort) {
+			logger.Debugf("Discovery: Sending broadcast to %s (interval %v): %s", addr, interval, string(data))
+			if _, err := conn.WriteToUDP(data, addr); err != nil {
+				logger.Warnf("Discovery: Failed to send broadcast to %s: %v", addr, err)
+			}
+		}
+		if conn6 != nil {
+			for _, addr := range allNodesAddrs(cfg.UdpPort) {
+				logger.Debugf("Discovery: Sending multicast to %s", addr)
+				if _, err := conn6.WriteToUDP(data, addr); err != nil {
+					logger.Debugf("Discovery: Failed to send multicast to %s: %v", addr, err)
+				}
+			}
+		}
+
+		sent++
+		if sent >= cfg.BroadcastCount {
+			interval = min(interval*2, max(cfg.BroadcastMaxInterval.Duration, cfg.BroadcastInterval.Duration))
+		}
+
+		select {
+		case <-d.newPeer:
+			interval = cfg.BroadcastInterval.Duration
+			sent = 0
+		case <-time.After(retry.Jitter(interval)):
+		}
+	}
+}
+
+// broadcastAddrs returns the directed broadcast address of each interface, looked up
+// every time since interfaces come and go. The limited broadcast address is the fallback.
+func broadcastAddrs(port int) []*net.UDPAddr {
+	var addrs []*net.UDPAddr
+	seen := make(map[string]bool)
+	for _, iface := range netutil.InterfaceAddrs() {
+		if iface.Broadcast == nil || seen[iface.Broadcast.String()] {
+			continue
+		}
+		seen[iface.Broadcast.String()] = true
+		addrs = append(addrs, &net.UDPAddr{IP: iface.Broadcast, Port: port})
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, &net.UDPAddr{IP: net.ParseIP(BroadcastAddr), Port: port})
+	}
+	return addrs
+}
+
+// allNodesAddrs returns the IPv6 all-nodes group on each interface with an IPv6 address.
+func allNodesAddrs(port int) []*net.UDPAddr {
+	var addrs []*net.UDPAddr
+	seen := make(map[string]bool)
+	for _, iface := range netutil.InterfaceAddrs() {
+		if !iface.Multicast || iface.IPNet.IP.To4() != nil || seen[iface.Interface] {
+			continue
+		}
+		seen[iface.Interface] = true
+		addrs = append(addrs, &net.UDPAddr{IP: net.ParseIP(AllNodesAddr), Port: port, Zone: iface.Interface})
+	}
+	return addrs
+}
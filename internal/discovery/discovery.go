@@ -1,100 +1,116 @@
 package discovery
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
-	"net"
 	"time"
 
 	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
 	"example.com/web-service/internal/websocket"
 )
 
-const (
-	BroadcastInterval = 1 * time.Second
-	BroadcastCount    = 3
-	BroadcastAddr     = "255.255.255.255"
-)
+// expireCheckInterval is how often peers are checked against their TTL
+const expireCheckInterval = 5 * time.Second
 
-type DiscoveryMessage struct {
-	ClientID string `json:"clientId"`
-	Port     int    `json:"port"`
-	IP       string `json:"ip"` // Optional, receiver can use remote addr
+// Backend is one discovery mechanism. Run announces this device and reports the
+// peers it finds to d.Found; it blocks until the backend fails.
+type Backend interface {
+	Name() string
+	Run(d *Discovery) error
 }
 
-type CloudServerInfo struct {
-	WSUrl string `json:"wsUrl"`
+// backends by the names used in the config
+var backends = map[string]func() Backend{
+	"broadcast": func() Backend { return &broadcastBackend{} },
+	"mdns":      func() Backend { return &mdnsBackend{} },
 }
 
-func Start(manager *websocket.ClientManager) {
-	// Start listening for UDP responses/broadcasts
-	go listenForCloudServers(manager)
+// Peer is a device found by a backend.
+type Peer struct {
+	ClientID string
+	Address  string // host:port of its HTTP/WS server
+	Info     pairing.DeviceInfo
+	TTL      time.Duration // 0 uses the configured PeerTTL
+}
 
-	// Send broadcasts
-	go sendBroadcasts()
+// Discovery finds the peers of one agent instance and connects to those that are paired.
+type Discovery struct {
+	cfg     *config.Config
+	keys    *pairing.Keystore
+	manager *websocket.ClientManager // nil only records what is found
+	// newPeer is signalled when a peer is heard for the first time, so we announce ourselves to it quickly.
+	newPeer chan struct{}
 }
 
-func sendBroadcasts() {
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", BroadcastAddr, config.UdpPort))
-	if err != nil {
-		log.Printf("Discovery: Failed to resolve broadcast address: %v", err)
-		return
+func New(cfg *config.Config, keys *pairing.Keystore, manager *websocket.ClientManager) *Discovery {
+	return &Discovery{
+		cfg:     cfg,
+		keys:    keys,
+		manager: manager,
+		newPeer: make(chan struct{}, 1),
+	}
+}
+
+// NotifyNewPeer resets the announcement interval after a new peer appeared.
+func (d *Discovery) NotifyNewPeer() {
+	select {
+	case d.newPeer <- struct{}{}:
+	default:
 	}
+}
 
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		log.Printf("Discovery: Failed to dial UDP: %v", err)
-		return
+// Start runs the discovery backends enabled in the config.
+func (d *Discovery) Start() {
+	for _, name := range d.cfg.Discovery {
+		newBackend, ok := backends[name]
+		if !ok {
+			logger.Warnf("Discovery: Unknown backend %q", name)
+			continue
+		}
+		backend := newBackend()
+		go func() {
+			if err := backend.Run(d); err != nil {
+				logger.Errorf("Discovery: %s stopped: %v", backend.Name(), err)
+			}
+		}()
 	}
-	defer conn.Close()
 
-	msg := DiscoveryMessage{
-		ClientID: config.ClientID,
-		Port:     config.HttpPort, // Local Server's HTTP/WS port
+	// Drop peers that stopped announcing themselves
+	go d.expirePeers()
+}
+
+// Found records a peer reported by a backend and connects to it if it is paired.
+func (d *Discovery) Found(peer Peer) {
+	if d.keys.NoteDiscovered(peer.ClientID, peer.Address, peer.Info, peer.TTL) {
+		// Answer a newcomer quickly instead of at our backed-off interval
+		d.NotifyNewPeer()
 	}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Discovery: Failed to marshal message: %v", err)
+	// Only paired peers are trusted; others stay visible for pairing
+	if !d.keys.IsPaired(peer.ClientID) {
+		log.Printf("Discovered unpaired peer: %s at %s (ClientID: %s)", peer.Info.DeviceName, peer.Address, peer.ClientID)
 		return
 	}
 
-	for i := 0; i < BroadcastCount; i++ {
-		log.Printf("Discovery: Sending broadcast %d/%d: %s", i+1, BroadcastCount, string(data))
-		_, err := conn.Write(data)
-		if err != nil {
-			log.Printf("Discovery: Failed to send broadcast: %v", err)
-		}
-		time.Sleep(BroadcastInterval)
+	if d.manager == nil || d.manager.IsConnected(peer.ClientID) {
+		return
 	}
+
+	log.Printf("Discovered Peer: %s at %s (ClientID: %s)", peer.Info.DeviceName, peer.Address, peer.ClientID)
+	d.manager.ConnectToCloud(peer.Address, peer.ClientID)
 }
 
-func listenForCloudServers(manager *websocket.ClientManager) {
-	// We reuse the UDP port logic or listen on a specific port?
-	// Assuming cloud server replies to the source port or broadcasts to the same port.
-	// Since main.go already starts `server.StartUDP()` which listens on config.UdpPort (8001),
-	// we have a conflict if we try to listen on 8001 here again.
-	//
-	// SOLUTION: We should integrate this logic into `server.StartUDP()` OR
-	// modify `server.StartUDP()` to handle discovery messages.
-	//
-	// However, if the requirement is "CloudServerURL from received UDP",
-	// it implies we are listening.
-	//
-	// Given the user instruction "local-server simultaneously acts as websocket client and server",
-	// and "CloudServerURL obtained from received UDP",
-	// let's assume `server.StartUDP` is the place receiving these messages.
-	//
-	// We will Modify `internal/server/udp_server.go` to handle Cloud Server discovery logic instead of here.
-	// This file will only handle the broadcasting part if we want to keep it separate,
-	// or we can move the broadcast logic to `server` package too.
-	//
-	// Let's keep `sendBroadcasts` here and call it.
-	// But `listenForCloudServers` is redundant if `server.StartUDP` does the job.
-	//
-	// Wait, `server.StartUDP` listens on 8001.
-	// The broadcast is sent to 8001.
-	// So `server.StartUDP` WILL receive its own broadcast (which it ignores).
-	// It will also receive broadcasts/responses from Cloud Server.
+// expirePeers forgets peers whose announcements stopped and stops connecting to them.
+func (d *Discovery) expirePeers() {
+	ticker := time.NewTicker(expireCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, clientID := range d.keys.ExpireDiscovered(d.cfg.PeerTTL.Duration) {
+			log.Printf("Discovery: Peer %s expired", clientID)
+			if d.manager != nil {
+				d.manager.Expire(clientID)
+			}
+		}
+	}
 }
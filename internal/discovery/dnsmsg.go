@@ -0,0 +1,249 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Just enough of the DNS wire format (RFC 1035) for mDNS service discovery.
+
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsTypeANY  = 255
+
+	dnsClassIN = 1
+	// mdnsCacheFlush marks records we own exclusively (RFC 6762, section 10.2)
+	mdnsCacheFlush = 0x8000
+	// mdnsUnicastResponse in a question asks for a unicast reply (RFC 6762, section 5.4)
+	mdnsUnicastResponse = 0x8000
+
+	dnsFlagResponse      = 0x8000
+	dnsFlagAuthoritative = 0x0400
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+// dnsRecord is a resource record. Only the fields of its type are set.
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+
+	target string   // PTR, SRV
+	port   uint16   // SRV
+	txt    []string // TXT
+	ip     net.IP   // A, AAAA
+}
+
+type dnsMessage struct {
+	id        uint16
+	flags     uint16
+	questions []dnsQuestion
+	answers   []dnsRecord // Answer, authority and additional sections
+}
+
+func (m *dnsMessage) isResponse() bool {
+	return m.flags&dnsFlagResponse != 0
+}
+
+// pack encodes the message without name compression; all records go into the answer section.
+func (m *dnsMessage) pack() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.id)
+	binary.BigEndian.PutUint16(b[2:], m.flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.answers)))
+
+	var err error
+	for _, q := range m.questions {
+		if b, err = appendName(b, q.name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.qtype)
+		b = binary.BigEndian.AppendUint16(b, q.class)
+	}
+
+	for _, r := range m.answers {
+		if b, err = appendName(b, r.name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, r.rtype)
+		b = binary.BigEndian.AppendUint16(b, r.class)
+		b = binary.BigEndian.AppendUint32(b, r.ttl)
+
+		lengthAt := len(b)
+		b = append(b, 0, 0)
+		switch r.rtype {
+		case dnsTypePTR:
+			b, err = appendName(b, r.target)
+		case dnsTypeSRV:
+			b = binary.BigEndian.AppendUint16(b, 0) // Priority
+			b = binary.BigEndian.AppendUint16(b, 0) // Weight
+			b = binary.BigEndian.AppendUint16(b, r.port)
+			b, err = appendName(b, r.target)
+		case dnsTypeTXT:
+			for _, s := range r.txt {
+				if len(s) > 255 {
+					return nil, errors.New("TXT string too long")
+				}
+				b = append(b, byte(len(s)))
+				b = append(b, s...)
+			}
+			if len(r.txt) == 0 {
+				b = append(b, 0)
+			}
+		case dnsTypeA:
+			b = append(b, r.ip.To4()...)
+		case dnsTypeAAAA:
+			b = append(b, r.ip.To16()...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint16(b[lengthAt:], uint16(len(b)-lengthAt-2))
+	}
+	return b, nil
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			return nil, errors.New("DNS label too long: " + label)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// parseDNSMessage decodes a message, following compressed names.
+func parseDNSMessage(b []byte) (*dnsMessage, error) {
+	if len(b) < 12 {
+		return nil, errMalformed
+	}
+	m := &dnsMessage{
+		id:    binary.BigEndian.Uint16(b[0:]),
+		flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	qdCount := int(binary.BigEndian.Uint16(b[4:]))
+	rrCount := int(binary.BigEndian.Uint16(b[6:])) + int(binary.BigEndian.Uint16(b[8:])) + int(binary.BigEndian.Uint16(b[10:]))
+
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+4 > len(b) {
+			return nil, errMalformed
+		}
+		m.questions = append(m.questions, dnsQuestion{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(b[next:]),
+			class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+
+	for i := 0; i < rrCount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+10 > len(b) {
+			return nil, errMalformed
+		}
+		r := dnsRecord{
+			name:  name,
+			rtype: binary.BigEndian.Uint16(b[next:]),
+			class: binary.BigEndian.Uint16(b[next+2:]),
+			ttl:   binary.BigEndian.Uint32(b[next+4:]),
+		}
+		length := int(binary.BigEndian.Uint16(b[next+8:]))
+		start := next + 10
+		end := start + length
+		if end > len(b) {
+			return nil, errMalformed
+		}
+		data := b[start:end]
+
+		switch r.rtype {
+		case dnsTypePTR:
+			if r.target, _, err = readName(b, start); err != nil {
+				return nil, err
+			}
+		case dnsTypeSRV:
+			if length < 7 {
+				return nil, errMalformed
+			}
+			r.port = binary.BigEndian.Uint16(data[4:])
+			if r.target, _, err = readName(b, start+6); err != nil {
+				return nil, err
+			}
+		case dnsTypeTXT:
+			for len(data) > 0 {
+				n := int(data[0])
+				if 1+n > len(data) {
+					return nil, errMalformed
+				}
+				if n > 0 {
+					r.txt = append(r.txt, string(data[1:1+n]))
+				}
+				data = data[1+n:]
+			}
+		case dnsTypeA, dnsTypeAAAA:
+			if length == net.IPv4len || length == net.IPv6len {
+				r.ip = net.IP(append([]byte{}, data...))
+			}
+		}
+		m.answers = append(m.answers, r)
+		off = end
+	}
+	return m, nil
+}
+
+// readName reads a possibly compressed name at off and returns it with the offset after it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errMalformed
+		}
+		length := int(b[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(b) || jumps > 10 {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+			jumps++
+		case length&0xC0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+length > len(b) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
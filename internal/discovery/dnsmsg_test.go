@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func testMessage() *dnsMessage {
+	return &dnsMessage{
+		id:    7,
+		flags: dnsFlagResponse | dnsFlagAuthoritative,
+		questions: []dnsQuestion{
+			{name: mdnsService, qtype: dnsTypePTR, class: dnsClassIN | mdnsUnicastResponse},
+		},
+		answers: []dnsRecord{
+			{name: mdnsService, rtype: dnsTypePTR, class: dnsClassIN, ttl: 90, target: "a." + mdnsService},
+			{name: "a." + mdnsService, rtype: dnsTypeSRV, class: dnsClassIN | mdnsCacheFlush, ttl: 90, port: 8000, target: "a.local."},
+			{name: "a." + mdnsService, rtype: dnsTypeTXT, class: dnsClassIN | mdnsCacheFlush, ttl: 90, txt: []string{"id=a", "name=Desk", "v=2"}},
+			{name: "a.local.", rtype: dnsTypeA, class: dnsClassIN | mdnsCacheFlush, ttl: 90, ip: net.IPv4(192, 0, 2, 1).To4()},
+			{name: "a.local.", rtype: dnsTypeAAAA, class: dnsClassIN | mdnsCacheFlush, ttl: 90, ip: net.ParseIP("fe80::1")},
+		},
+	}
+}
+
+func TestPackParseRoundTrip(t *testing.T) {
+	want := testMessage()
+	b, err := want.pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseDNSMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed the message:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	b, err := testMessage().pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(b); n++ {
+		if _, err := parseDNSMessage(b[:n]); err == nil {
+			t.Errorf("parsed a message truncated to %d of %d bytes", n, len(b))
+		}
+	}
+}
+
+// header returns a message header with the given question and answer counts.
+func header(questions, answers uint16) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[2:], dnsFlagResponse)
+	binary.BigEndian.PutUint16(b[4:], questions)
+	binary.BigEndian.PutUint16(b[6:], answers)
+	return b
+}
+
+func TestParseCompressedNames(t *testing.T) {
+	// A question for the service, then a PTR record whose name points at the question's
+	// name and whose target is a label followed by a pointer to it as well
+	b := header(1, 1)
+	b, _ = appendName(b, mdnsService)
+	b = binary.BigEndian.AppendUint16(b, dnsTypePTR)
+	b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+
+	b = append(b, 0xC0, 12)
+	b = binary.BigEndian.AppendUint16(b, dnsTypePTR)
+	b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+	b = binary.BigEndian.AppendUint32(b, 90)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = append(b, 1, 'a', 0xC0, 12)
+
+	msg, err := parseDNSMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(msg.answers))
+	}
+	r := msg.answers[0]
+	if r.name != mdnsService || r.target != "a."+mdnsService || r.ttl != 90 {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestParseRejectsMalformedNames(t *testing.T) {
+	tests := map[string][]byte{
+		// A pointer to itself
+		"loop": append(header(1, 0), 0xC0, 12, 0, dnsTypePTR, 0, dnsClassIN),
+		// Two pointers to each other
+		"cycle": append(header(1, 0), 0xC0, 14, 0xC0, 12, 0, dnsTypePTR, 0, dnsClassIN),
+		// A pointer past the end of the message
+		"pointer out of range": append(header(1, 0), 0xC0, 0xFF, 0, dnsTypePTR, 0, dnsClassIN),
+		// A label longer than the message
+		"label out of range": append(header(1, 0), 40, 'a', 'b'),
+		// The reserved label types 01 and 10
+		"reserved label": append(header(1, 0), 0x40, 0, 0, dnsTypePTR, 0, dnsClassIN),
+	}
+	for name, b := range tests {
+		if _, err := parseDNSMessage(b); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestPackRejectsLongLabels(t *testing.T) {
+	long := make([]byte, 64)
+	for i := range long {
+		long[i] = 'a'
+	}
+	msg := &dnsMessage{questions: []dnsQuestion{{name: string(long) + ".local.", qtype: dnsTypePTR, class: dnsClassIN}}}
+	if _, err := msg.pack(); err == nil {
+		t.Error("packed a 64-byte label")
+	}
+}
@@ -0,0 +1,255 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/retry"
+	"example.com/web-service/internal/websocket"
+)
+
+const (
+	mdnsAddr    = "224.0.0.251:5353"
+	mdnsAddr6   = "ff02::fb"
+	mdnsPort    = 5353
+	mdnsService = "_pasteflow._tcp.local."
+)
+
+// mdnsBackend advertises this device as a DNS-SD service over multicast DNS and
+// browses for other instances. Multicast reaches networks that drop broadcasts.
+// IPv4 uses one socket; IPv6 uses one per interface since its group is link-local.
+type mdnsBackend struct {
+	d        *Discovery
+	cfg      *config.Config
+	conns    []mdnsConn
+	instance string // <client ID>._pasteflow._tcp.local.
+	host     string // <client ID>.local.
+}
+
+type mdnsConn struct {
+	conn  *net.UDPConn
+	group *net.UDPAddr // Where to send, with the zone for IPv6
+}
+
+func (b *mdnsBackend) Name() string {
+	return "mdns"
+}
+
+func (b *mdnsBackend) Run(d *Discovery) error {
+	b.d = d
+	b.cfg = d.cfg
+	b.instance = b.cfg.ClientID + "." + mdnsService
+	b.host = b.cfg.ClientID + ".local."
+
+	if group, err := net.ResolveUDPAddr("udp4", mdnsAddr); err == nil {
+		b.join("udp4", nil, group, group)
+	}
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || !hasIPv6(iface) {
+			continue
+		}
+		group := &net.UDPAddr{IP: net.ParseIP(mdnsAddr6), Port: mdnsPort}
+		b.join("udp6", &iface, group, &net.UDPAddr{IP: group.IP, Port: mdnsPort, Zone: iface.Name})
+	}
+	if len(b.conns) == 0 {
+		return fmt.Errorf("failed to join any mDNS group")
+	}
+	log.Printf("Discovery: Advertising %s over mDNS on %d sockets", b.instance, len(b.conns))
+
+	// Announce ourselves, then keep asking for others
+	for _, c := range b.conns {
+		b.send(c, b.response())
+	}
+	go b.browse()
+
+	errs := make(chan error, len(b.conns))
+	for _, c := range b.conns {
+		go func() { errs <- b.read(c) }()
+	}
+	var err error
+	for range b.conns {
+		err = <-errs
+	}
+	return err
+}
+
+func (b *mdnsBackend) join(network string, iface *net.Interface, group, sendTo *net.UDPAddr) {
+	conn, err := net.ListenMulticastUDP(network, iface, group)
+	if err != nil {
+		logger.Warnf("Discovery: Failed to join mDNS group %s: %v", sendTo, err)
+		return
+	}
+	if err := enableMulticastLoopback(conn, network == "udp6"); err != nil {
+		logger.Warnf("Discovery: Failed to enable mDNS loopback: %v", err)
+	}
+	b.conns = append(b.conns, mdnsConn{conn: conn, group: sendTo})
+}
+
+func (b *mdnsBackend) read(c mdnsConn) error {
+	defer c.conn.Close()
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		msg, err := parseDNSMessage(buf[:n])
+		if err != nil {
+			logger.Debugf("Discovery: Ignoring mDNS packet from %s: %v", src, err)
+			continue
+		}
+		if msg.isResponse() {
+			b.handleResponse(msg, src)
+		} else {
+			b.handleQuery(c, msg)
+		}
+	}
+}
+
+func hasIPv6(iface net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// browse queries for the service, first at the broadcast interval, then backing off.
+func (b *mdnsBackend) browse() {
+	query := &dnsMessage{questions: []dnsQuestion{{name: mdnsService, qtype: dnsTypePTR, class: dnsClassIN}}}
+	interval := b.cfg.BroadcastInterval.Duration
+	for sent := 1; ; sent++ {
+		for _, c := range b.conns {
+			b.send(c, query)
+		}
+		if sent >= b.cfg.BroadcastCount {
+			interval = min(interval*2, max(b.cfg.BroadcastMaxInterval.Duration, b.cfg.BroadcastInterval.Duration))
+		}
+		time.Sleep(retry.Jitter(interval))
+	}
+}
+
+func (b *mdnsBackend) send(c mdnsConn, msg *dnsMessage) {
+	data, err := msg.pack()
+	if err != nil {
+		logger.Errorf("Discovery: Failed to encode mDNS message: %v", err)
+		return
+	}
+	if _, err := c.conn.WriteToUDP(data, c.group); err != nil {
+		logger.Debugf("Discovery: Failed to send mDNS message to %s: %v", c.group, err)
+	}
+}
+
+// handleQuery answers questions about the service, our instance or our host name
+// on the socket the query arrived on.
+func (b *mdnsBackend) handleQuery(c mdnsConn, msg *dnsMessage) {
+	for _, q := range msg.questions {
+		if strings.EqualFold(q.name, mdnsService) || strings.EqualFold(q.name, b.instance) || strings.EqualFold(q.name, b.host) {
+			b.send(c, b.response())
+			return
+		}
+	}
+}
+
+// response describes this device: PTR to the instance, its SRV and TXT records and our addresses.
+func (b *mdnsBackend) response() *dnsMessage {
+	ttl := uint32(b.cfg.PeerTTL.Seconds())
+	msg := &dnsMessage{
+		flags: dnsFlagResponse | dnsFlagAuthoritative,
+		answers: []dnsRecord{
+			{name: mdnsService, rtype: dnsTypePTR, class: dnsClassIN, ttl: ttl, target: b.instance},
+			{name: b.instance, rtype: dnsTypeSRV, class: dnsClassIN | mdnsCacheFlush, ttl: ttl, port: uint16(b.cfg.HttpPort), target: b.host},
+			{name: b.instance, rtype: dnsTypeTXT, class: dnsClassIN | mdnsCacheFlush, ttl: ttl, txt: []string{
+				"id=" + b.cfg.ClientID,
+				"name=" + truncate(b.cfg.DeviceName, 250),
+				"platform=" + b.cfg.Platform,
+				"v=" + strconv.Itoa(websocket.ProtocolVersion),
+			}},
+		},
+	}
+
+	for _, addr := range netutil.InterfaceAddrs() {
+		rtype := uint16(dnsTypeAAAA)
+		if addr.IPNet.IP.To4() != nil {
+			rtype = dnsTypeA
+		}
+		msg.answers = append(msg.answers, dnsRecord{name: b.host, rtype: rtype, class: dnsClassIN | mdnsCacheFlush, ttl: ttl, ip: addr.IPNet.IP})
+	}
+	return msg
+}
+
+// handleResponse reports every instance of the service described in a response.
+func (b *mdnsBackend) handleResponse(msg *dnsMessage, src *net.UDPAddr) {
+	srv := make(map[string]dnsRecord)
+	txt := make(map[string]dnsRecord)
+	for _, r := range msg.answers {
+		name := strings.ToLower(r.name)
+		if !strings.HasSuffix(name, mdnsService) {
+			continue
+		}
+		switch r.rtype {
+		case dnsTypeSRV:
+			srv[name] = r
+		case dnsTypeTXT:
+			txt[name] = r
+		}
+	}
+
+	for name, s := range srv {
+		t, ok := txt[name]
+		if !ok || s.ttl == 0 {
+			// Incomplete, or a goodbye; the peer expires with its TTL
+			continue
+		}
+
+		values := make(map[string]string)
+		for _, kv := range t.txt {
+			if key, value, ok := strings.Cut(kv, "="); ok {
+				values[strings.ToLower(key)] = value
+			}
+		}
+		clientID := values["id"]
+		if clientID == "" || clientID == b.cfg.ClientID {
+			continue
+		}
+
+		logger.Debugf("Discovery: mDNS response from %s for %s: %v", src, name, t.txt)
+		// The source address is the one that reached us, unlike the A records which may list all interfaces
+		host := src.IP.String()
+		if src.Zone != "" {
+			host += "%" + src.Zone
+		}
+		b.d.Found(Peer{
+			ClientID: clientID,
+			Address:  net.JoinHostPort(host, strconv.Itoa(int(s.port))),
+			Info:     pairing.DeviceInfo{DeviceName: values["name"], Platform: values["platform"]},
+			TTL:      time.Duration(s.ttl) * time.Second,
+		})
+	}
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
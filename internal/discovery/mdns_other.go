@@ -0,0 +1,10 @@
+//go:build !unix
+
+package discovery
+
+import "net"
+
+// enableMulticastLoopback is not supported here; instances on the same host will not see each other over mDNS.
+func enableMulticastLoopback(conn *net.UDPConn, ipv6 bool) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/pairing"
+)
+
+// newTestDiscovery returns a discovery that only records what it finds.
+func newTestDiscovery(t *testing.T, clientID string, port int) (*Discovery, *pairing.Keystore) {
+	cfg := config.Default()
+	cfg.ClientID = clientID
+	cfg.DeviceName = "device " + clientID
+	cfg.HttpPort = port
+	cfg.DataDir = t.TempDir()
+	cfg.BroadcastInterval = config.Duration{Duration: 100 * time.Millisecond}
+	keys := pairing.NewKeystore(cfg)
+	return New(cfg, keys, nil), keys
+}
+
+func TestMDNSBackendsFindEachOther(t *testing.T) {
+	a, keysA := newTestDiscovery(t, "test-a-"+t.Name(), 18000)
+	b, keysB := newTestDiscovery(t, "test-b-"+t.Name(), 19000)
+
+	errs := make(chan error, 2)
+	for _, d := range []*Discovery{a, b} {
+		go func() { errs <- (&mdnsBackend{}).Run(d) }()
+	}
+
+	deadline := time.After(10 * time.Second)
+	for {
+		addrA, okA := keysB.AddressOf(a.cfg.ClientID)
+		addrB, okB := keysA.AddressOf(b.cfg.ClientID)
+		if okA && okB {
+			if !strings.HasSuffix(addrA, ":18000") || !strings.HasSuffix(addrB, ":19000") {
+				t.Errorf("found %s and %s, want the HTTP ports 18000 and 19000", addrA, addrB)
+			}
+			return
+		}
+
+		select {
+		case err := <-errs:
+			t.Skipf("mDNS is not available: %v", err)
+		case <-deadline:
+			t.Fatalf("backends did not find each other (a found b: %v, b found a: %v)", okB, okA)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
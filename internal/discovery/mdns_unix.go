@@ -0,0 +1,29 @@
+//go:build unix
+
+package discovery
+
+import (
+	"net"
+	"syscall"
+)
+
+// enableMulticastLoopback lets other instances on this host hear our packets.
+// net.ListenMulticastUDP turns loopback off.
+func enableMulticastLoopback(conn *net.UDPConn, ipv6 bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
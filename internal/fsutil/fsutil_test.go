@@ -0,0 +1,24 @@
+package fsutil
+
+import "testing"
+
+func TestIsPlainName(t *testing.T) {
+	tests := map[string]bool{
+		"notes.txt":      true,
+		"photo 2.png":    true,
+		".bashrc":        true,
+		"":               false,
+		".":              false,
+		"..":             false,
+		"../.bashrc":     false,
+		"../../.bashrc":  false,
+		"dir/notes.txt":  false,
+		"/etc/passwd":    false,
+		"a/../../escape": false,
+	}
+	for name, want := range tests {
+		if got := IsPlainName(name); got != want {
+			t.Errorf("IsPlainName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"example.com/web-service/internal/models"
+	"github.com/google/uuid"
+)
+
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateSkipped   State = "skipped"
+)
+
+const (
+	// MaxFinishedJobs bounds how many finished jobs are kept for status queries
+	MaxFinishedJobs = 100
+)
+
+// FileResult is the per-entry outcome of a paste job.
+type FileResult struct {
+	Name             string `json:"name"`
+	Source           string `json:"source"`
+	Dest             string `json:"dest"`
+	IsDir            bool   `json:"isDir,omitempty"`
+	Size             int64  `json:"size"`
+	BytesTransferred int64  `json:"bytesTransferred"`
+	State            State  `json:"state"`
+	Conflict         string `json:"conflict,omitempty"` // Action taken for an existing destination
+	Error            string `json:"error,omitempty"`
+}
+
+// Status is a point-in-time view of a paste job.
+type Status struct {
+	ID          string       `json:"id"`
+	State       State        `json:"state"`
+	Destination string       `json:"destination"`
+	TotalSize   int64        `json:"totalSize"`
+	Transferred int64        `json:"bytesTransferred"`
+	Files       []FileResult `json:"files"`
+	Success     int          `json:"success"`
+	Failure     int          `json:"failure"`
+	Skipped     int          `json:"skipped"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	CompletedAt *time.Time   `json:"completedAt,omitempty"`
+}
+
+// Job tracks a single paste operation.
+type Job struct {
+	ID       string
+	registry *Registry
+	mu       sync.Mutex
+	status   Status
+}
+
+// Registry keeps the jobs of one agent instance for status queries.
+type Registry struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	finished []string // IDs of finished jobs, oldest first
+}
+
+func NewRegistry() *Registry {
+	return &Registry{jobs: make(map[string]*Job)}
+}
+
+// New registers a pending job for pasting files into destination.
+func (r *Registry) New(destination string, files []models.FileData) *Job {
+	id := uuid.New().String()
+	job := &Job{
+		ID:       id,
+		registry: r,
+		status: Status{
+			ID:          id,
+			State:       StatePending,
+			Destination: destination,
+			Files:       make([]FileResult, len(files)),
+			CreatedAt:   time.Now(),
+		},
+	}
+	for i, file := range files {
+		job.status.Files[i] = FileResult{
+			Name:   file.Name,
+			Source: file.Path,
+			IsDir:  file.IsDir,
+			Size:   file.Size,
+			State:  StatePending,
+		}
+		job.status.TotalSize += file.Size
+	}
+
+	r.mu.Lock()
+	r.jobs[job.ID] = job
+	r.mu.Unlock()
+
+	log.Printf("Created paste job %s: Dest=%s, FilesCount=%d", job.ID, destination, len(files))
+	return job
+}
+
+// Get returns a snapshot of the job with the given ID.
+func (r *Registry) Get(id string) (Status, bool) {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	r.mu.Unlock()
+	if !ok {
+		return Status{}, false
+	}
+	return job.Snapshot(), true
+}
+
+// Snapshot returns a copy of the job status that is safe to read and encode.
+func (j *Job) Snapshot() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	snapshot := j.status
+	snapshot.Files = append([]FileResult(nil), j.status.Files...)
+	if j.status.CompletedAt != nil {
+		completedAt := *j.status.CompletedAt
+		snapshot.CompletedAt = &completedAt
+	}
+	return snapshot
+}
+
+// Start marks the job as running.
+func (j *Job) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.State = StateRunning
+}
+
+// StartFile marks entry i as running towards dest. conflict describes how an
+// existing destination was handled, if any.
+func (j *Job) StartFile(i int, dest, conflict string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Files[i].Dest = dest
+	j.status.Files[i].Conflict = conflict
+	j.status.Files[i].State = StateRunning
+}
+
+// SkipFile marks entry i as skipped because dest already exists.
+func (j *Job) SkipFile(i int, dest string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Files[i].Dest = dest
+	j.status.Files[i].Conflict = "skipped"
+	j.status.Files[i].State = StateSkipped
+	j.status.Skipped++
+}
+
+// SetBytes records how many bytes of entry i have been transferred so far.
+func (j *Job) SetBytes(i int, written int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Transferred += written - j.status.Files[i].BytesTransferred
+	j.status.Files[i].BytesTransferred = written
+}
+
+// FinishFile records the outcome of entry i.
+func (j *Job) FinishFile(i int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		j.status.Files[i].State = StateFailed
+		j.status.Files[i].Error = err.Error()
+		j.status.Failure++
+		return
+	}
+	j.status.Files[i].State = StateCompleted
+	j.status.Success++
+}
+
+// Finish marks the job as done. A job with any failed entry is reported as failed.
+func (j *Job) Finish() {
+	j.mu.Lock()
+	now := time.Now()
+	j.status.CompletedAt = &now
+	if j.status.Failure > 0 {
+		j.status.State = StateFailed
+	} else {
+		j.status.State = StateCompleted
+	}
+	log.Printf("Paste job %s finished: success=%d, failure=%d, skipped=%d", j.ID, j.status.Success, j.status.Failure, j.status.Skipped)
+	j.mu.Unlock()
+
+	r := j.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished = append(r.finished, j.ID)
+	for len(r.finished) > MaxFinishedJobs {
+		delete(r.jobs, r.finished[0])
+		r.finished = r.finished[1:]
+	}
+}
@@ -1,13 +1,82 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 )
 
-// Setup sets up logging to stdout only.
-func Setup() {
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	level = LevelInfo
+	// errorLog keeps warnings and errors visible when plain log output is silenced
+	errorLog = log.New(os.Stdout, "", log.LstdFlags)
+)
+
+// Setup sets up logging to stdout only. Messages written with the standard log
+// package are info level; they are dropped when level is "warn" or "error".
+// An unknown level logs at info and says so.
+func Setup(levelName string) {
 	// Go's default logging includes date and time
 	log.SetFlags(log.LstdFlags)
 	log.SetOutput(os.Stdout)
+
+	parsed, err := ParseLevel(levelName)
+	level = parsed
+	if err != nil {
+		Warnf("%v, logging at info level", err)
+	}
+	if level > LevelInfo {
+		log.SetOutput(io.Discard)
+	}
+}
+
+// ParseLevel converts a level name. Unknown names are an error and mean info.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// Debugf logs verbose per-message details.
+func Debugf(format string, v ...interface{}) {
+	if level <= LevelDebug {
+		log.Printf(format, v...)
+	}
+}
+
+// Warnf logs problems the agent recovers from, such as a failed peer connection.
+func Warnf(format string, v ...interface{}) {
+	if level <= LevelWarn {
+		errorLog.Printf("WARN "+format, v...)
+	}
+}
+
+// Errorf logs regardless of the level, for failures that lose data or a request.
+func Errorf(format string, v ...interface{}) {
+	errorLog.Printf("ERROR "+format, v...)
+}
+
+// Fatalf logs regardless of the level and exits.
+func Fatalf(format string, v ...interface{}) {
+	errorLog.Fatalf("FATAL "+format, v...)
 }
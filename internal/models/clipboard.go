@@ -0,0 +1,19 @@
+package models
+
+// Representation is one MIME-typed form of a clipboard item, e.g. the same
+// selection as text/plain, text/html and text/rtf.
+type Representation struct {
+	Type  string `json:"type"`
+	Data  []byte `json:"data,omitempty"` // Inline content; empty when it must be fetched from the origin
+	Size  int64  `json:"size"`
+	Token string `json:"token,omitempty"` // Opaque handle used to fetch the content from its origin
+}
+
+type CopyContentData struct {
+	Representations []Representation `json:"representations"`
+	Index           int64            `json:"index,omitempty"`
+	IP              string           `json:"ip"`
+	Port            int              `json:"port"`
+	ClientID        string           `json:"clientId,omitempty"`
+	Clock           uint64           `json:"clock,omitempty"`
+}
@@ -1,14 +1,18 @@
 package models
 
 type FileData struct {
-	Path string `json:"path"`
-	Name string `json:"name"`
-	Size int64  `json:"size,omitempty"`
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size,omitempty"`
+	IsDir bool   `json:"isDir,omitempty"` // Directories are copied recursively and downloaded as a tar stream
+	Token string `json:"token,omitempty"` // Opaque handle used to download the file from its origin
 }
 
 type CopyFileInfoData struct {
-	Files []FileData `json:"files"`
-	Index int64      `json:"index,omitempty"`
-	IP    string     `json:"ip"`
-	Port  int        `json:"port"`
+	Files    []FileData `json:"files"`
+	Index    int64      `json:"index,omitempty"`
+	IP       string     `json:"ip"`
+	Port     int        `json:"port"`
+	ClientID string     `json:"clientId,omitempty"` // Origin of the announcement, used to authenticate downloads
+	Clock    uint64     `json:"clock,omitempty"`    // Lamport clock of the origin, orders concurrent copies
 }
@@ -0,0 +1,40 @@
+package models
+
+// PasteProgressData is sent to local clients while a pasted entry is transferring.
+type PasteProgressData struct {
+	JobID            string `json:"jobId"`
+	Destination      string `json:"destination"`
+	FileIndex        int    `json:"fileIndex"`
+	Name             string `json:"name"`
+	BytesTransferred int64  `json:"bytesTransferred"`
+	Size             int64  `json:"size"`
+	TotalTransferred int64  `json:"totalTransferred"`
+	TotalSize        int64  `json:"totalSize"`
+}
+
+// PasteFileDoneData is sent to local clients when a pasted entry finishes.
+type PasteFileDoneData struct {
+	JobID            string `json:"jobId"`
+	Destination      string `json:"destination"`
+	FileIndex        int    `json:"fileIndex"`
+	Name             string `json:"name"`
+	Dest             string `json:"dest"`
+	BytesTransferred int64  `json:"bytesTransferred"`
+	Size             int64  `json:"size"`
+	Success          bool   `json:"success"`
+	Skipped          bool   `json:"skipped,omitempty"`
+	Conflict         string `json:"conflict,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// PasteCompletedData is sent to local clients when a paste job finishes.
+type PasteCompletedData struct {
+	JobID            string `json:"jobId"`
+	Destination      string `json:"destination"`
+	State            string `json:"state"`
+	Success          int    `json:"success"`
+	Failure          int    `json:"failure"`
+	Skipped          int    `json:"skipped"`
+	TotalTransferred int64  `json:"totalTransferred"`
+	TotalSize        int64  `json:"totalSize"`
+}
@@ -0,0 +1,131 @@
+package netutil
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// IsLoopback reports whether a "host:port" remote address belongs to this machine.
+func IsLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// InterfaceAddr is an IPv4 or IPv6 address of an interface that is up and not loopback.
+type InterfaceAddr struct {
+	Interface string
+	IPNet     *net.IPNet
+	Broadcast net.IP // IPv4 subnet-directed broadcast address, nil if the interface cannot broadcast
+	Multicast bool
+}
+
+// InterfaceAddrs lists the addresses of all usable interfaces, e.g. Wi-Fi, Ethernet and VPN.
+func InterfaceAddrs() []InterfaceAddr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var list []InterfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			entry := InterfaceAddr{Interface: iface.Name, IPNet: ipnet, Multicast: iface.Flags&net.FlagMulticast != 0}
+			if iface.Flags&net.FlagBroadcast != 0 && ipnet.IP.To4() != nil {
+				entry.Broadcast = DirectedBroadcast(ipnet)
+			}
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// DirectedBroadcast returns the broadcast address of an IPv4 subnet, e.g. 192.168.1.255 for 192.168.1.0/24.
+func DirectedBroadcast(ipnet *net.IPNet) net.IP {
+	ip := ipnet.IP.To4()
+	mask := ipnet.Mask
+	if ip == nil || len(mask) != net.IPv4len {
+		return nil
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range ip {
+		broadcast[i] = ip[i] | ^mask[i]
+	}
+	return broadcast
+}
+
+// LocalIPFor returns the local IP the system routes from to reach host. No packet is sent.
+func LocalIPFor(host string) string {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "9"))
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// HostOf returns the IP of a net.Addr such as the local address of a connection.
+// An IPv6 zone is dropped since it only means something on this machine.
+func HostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	host, _, _ = strings.Cut(host, "%")
+	return host
+}
+
+// Zone returns the IPv6 zone of a "host:port" address or a host, e.g. "en0" for "[fe80::1%en0]:8000".
+func Zone(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	_, zone, _ := strings.Cut(host, "%")
+	return zone
+}
+
+// WithZone adds zone to a link-local IPv6 address that has none, as such an address
+// can only be reached through the interface it was learned on.
+func WithZone(host, zone string) string {
+	if zone == "" || strings.Contains(host, "%") {
+		return host
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil && ip.IsLinkLocalUnicast() {
+		return host + "%" + zone
+	}
+	return host
+}
+
+// URL builds a URL for host and port. IPv6 hosts are bracketed and zones escaped.
+func URL(scheme, host string, port int, path string, query url.Values) string {
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,109 @@
+package pairing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderClientID  = "X-Client-ID"
+	HeaderTimestamp = "X-PasteFlow-Timestamp"
+	HeaderNonce     = "X-PasteFlow-Nonce"
+	HeaderSignature = "X-PasteFlow-Signature"
+
+	// MaxClockSkew is how far a request timestamp may be from the local clock
+	MaxClockSkew = 60 * time.Second
+)
+
+var (
+	ErrNotPaired        = errors.New("peer is not paired")
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// SignRequest adds the headers authenticating a request to the paired peer peerID.
+// The signature covers our client ID, a timestamp, a random nonce, the method and the request URI.
+func (k *Keystore) SignRequest(header http.Header, peerID, method, requestURI string) error {
+	key, ok := k.keyFor(peerID)
+	if !ok {
+		return ErrNotPaired
+	}
+
+	nonceBytes := make([]byte, 16)
+	rand.Read(nonceBytes)
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	header.Set(HeaderClientID, k.clientID)
+	header.Set(HeaderTimestamp, timestamp)
+	header.Set(HeaderNonce, nonce)
+	header.Set(HeaderSignature, hex.EncodeToString(sign(key, k.clientID, timestamp, nonce, method, requestURI)))
+	return nil
+}
+
+// VerifyRequest checks the authentication headers of a request from a paired peer
+// and returns the peer's client ID.
+func (k *Keystore) VerifyRequest(r *http.Request) (string, error) {
+	clientID := r.Header.Get(HeaderClientID)
+	key, ok := k.keyFor(clientID)
+	if !ok {
+		return "", ErrNotPaired
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return "", fmt.Errorf("request timestamp outside of allowed skew: %v", skew)
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if !hmac.Equal(signature, sign(key, clientID, timestamp, nonce, r.Method, r.URL.RequestURI())) {
+		return "", ErrInvalidSignature
+	}
+
+	if !k.useNonce(nonce) {
+		return "", errors.New("replayed request")
+	}
+	return clientID, nil
+}
+
+func sign(key []byte, clientID, timestamp, nonce, method, requestURI string) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range []string{clientID, timestamp, nonce, method, requestURI} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+// useNonce records the nonce and reports whether it was unseen.
+func (k *Keystore) useNonce(nonce string) bool {
+	k.noncesMu.Lock()
+	defer k.noncesMu.Unlock()
+
+	now := time.Now()
+	for n, seen := range k.nonces {
+		if now.Sub(seen) > 2*MaxClockSkew {
+			delete(k.nonces, n)
+		}
+	}
+
+	if _, seen := k.nonces[nonce]; seen || nonce == "" {
+		return false
+	}
+	k.nonces[nonce] = now
+	return true
+}
@@ -0,0 +1,97 @@
+package pairing
+
+import (
+	"sort"
+	"time"
+)
+
+// DiscoveredPeer is a device seen on the network or paired earlier.
+// Unpaired peers are listed so they can be paired, but are never connected to.
+type DiscoveredPeer struct {
+	ClientID string `json:"clientId"`
+	DeviceInfo
+	Address  string     `json:"address,omitempty"` // host:port of the HTTP/WS server
+	Paired   bool       `json:"paired"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+type sighting struct {
+	address  string
+	info     DeviceInfo
+	lastSeen time.Time
+	ttl      time.Duration // As announced by the peer; 0 uses the default of ExpireDiscovered
+}
+
+// NoteDiscovered records that clientID announced itself at address, valid for ttl.
+// Announcements are not authenticated, so their device info is only kept in memory.
+// It reports whether the peer is new, i.e. unknown or expired before.
+func (k *Keystore) NoteDiscovered(clientID, address string, info DeviceInfo, ttl time.Duration) bool {
+	k.discoveredMu.Lock()
+	previous, known := k.discovered[clientID]
+	if info == (DeviceInfo{}) {
+		// Keep what an earlier announcement told us
+		info = previous.info
+	}
+	k.discovered[clientID] = sighting{address: address, info: info, lastSeen: time.Now(), ttl: ttl}
+	k.discoveredMu.Unlock()
+	return !known
+}
+
+// ExpireDiscovered forgets peers that have not announced themselves within their
+// TTL, or defaultTTL if they did not announce one, and returns their client IDs.
+func (k *Keystore) ExpireDiscovered(defaultTTL time.Duration) []string {
+	k.discoveredMu.Lock()
+	defer k.discoveredMu.Unlock()
+
+	var expired []string
+	for id, s := range k.discovered {
+		ttl := s.ttl
+		if ttl <= 0 {
+			ttl = defaultTTL
+		}
+		if time.Since(s.lastSeen) > ttl {
+			delete(k.discovered, id)
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// AddressOf returns the last known address of clientID.
+func (k *Keystore) AddressOf(clientID string) (string, bool) {
+	k.discoveredMu.Lock()
+	defer k.discoveredMu.Unlock()
+	s, ok := k.discovered[clientID]
+	return s.address, ok
+}
+
+// Peers lists discovered and paired devices.
+func (k *Keystore) Peers() []DiscoveredPeer {
+	result := make(map[string]*DiscoveredPeer)
+
+	k.discoveredMu.Lock()
+	for id, s := range k.discovered {
+		lastSeen := s.lastSeen
+		result[id] = &DiscoveredPeer{ClientID: id, DeviceInfo: s.info, Address: s.address, LastSeen: &lastSeen}
+	}
+	k.discoveredMu.Unlock()
+
+	k.peersMu.RLock()
+	for id, peer := range k.peers {
+		if _, ok := result[id]; !ok {
+			result[id] = &DiscoveredPeer{ClientID: id}
+		}
+		if result[id].DeviceInfo == (DeviceInfo{}) {
+			result[id].DeviceInfo = peer.DeviceInfo
+		}
+		result[id].Paired = true
+	}
+	k.peersMu.RUnlock()
+
+	list := make([]DiscoveredPeer, 0, len(result))
+	for _, peer := range result {
+		list = append(list, *peer)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	return list
+}
@@ -0,0 +1,111 @@
+package pairing
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const identifyTimeout = 5 * time.Second
+
+const (
+	// Sent with the WebSocket handshake so peers can show who connected.
+	// Values are percent-encoded since device names are often not ASCII.
+	HeaderDeviceName = "X-Device-Name"
+	HeaderPlatform   = "X-Platform"
+)
+
+// DeviceInfo is how a device presents itself to the user.
+type DeviceInfo struct {
+	DeviceName string `json:"deviceName,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+}
+
+// Self returns the device info of this instance.
+func (k *Keystore) Self() DeviceInfo {
+	return k.self
+}
+
+// AddDeviceHeaders adds our device name and platform to a request.
+func (k *Keystore) AddDeviceHeaders(header http.Header) {
+	header.Set(HeaderDeviceName, url.PathEscape(k.self.DeviceName))
+	header.Set(HeaderPlatform, url.PathEscape(k.self.Platform))
+}
+
+// DeviceInfoFrom reads the device name and platform sent by AddDeviceHeaders.
+func DeviceInfoFrom(header http.Header) DeviceInfo {
+	name, err := url.PathUnescape(header.Get(HeaderDeviceName))
+	if err != nil {
+		name = header.Get(HeaderDeviceName)
+	}
+	platform, err := url.PathUnescape(header.Get(HeaderPlatform))
+	if err != nil {
+		platform = header.Get(HeaderPlatform)
+	}
+	return DeviceInfo{DeviceName: name, Platform: platform}
+}
+
+// NoteDeviceInfo updates the name and platform remembered for a paired peer,
+// e.g. after the user renamed the device. It must only be given info the peer
+// authenticated, such as its hello or signed handshake.
+func (k *Keystore) NoteDeviceInfo(clientID string, info DeviceInfo) {
+	if info.DeviceName == "" && info.Platform == "" {
+		return
+	}
+
+	k.peersMu.Lock()
+	defer k.peersMu.Unlock()
+	peer, ok := k.peers[clientID]
+	if !ok || peer.DeviceInfo == info {
+		return
+	}
+	peer.DeviceInfo = info
+	k.peers[clientID] = peer
+	k.saveLocked()
+}
+
+// DeviceInfoOf returns the name and platform remembered for a paired or discovered peer.
+func (k *Keystore) DeviceInfoOf(clientID string) DeviceInfo {
+	k.discoveredMu.Lock()
+	info := k.discovered[clientID].info
+	k.discoveredMu.Unlock()
+	if info != (DeviceInfo{}) {
+		return info
+	}
+
+	k.peersMu.RLock()
+	defer k.peersMu.RUnlock()
+	return k.peers[clientID].DeviceInfo
+}
+
+// AddIdentityHeaders adds our client ID, device name and platform to a response, so a
+// peer added by address can learn who it is talking to.
+func (k *Keystore) AddIdentityHeaders(header http.Header) {
+	header.Set(HeaderClientID, k.clientID)
+	k.AddDeviceHeaders(header)
+}
+
+// Identify asks the device at address (host:port) for its client ID. The answer is not
+// authenticated; connections to the peer are, once it is paired.
+func Identify(address string) (string, DeviceInfo, error) {
+	client := &http.Client{
+		Timeout: identifyTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13},
+		},
+	}
+	helloURL := url.URL{Scheme: "https", Host: address, Path: "/hello"}
+	resp, err := client.Get(helloURL.String())
+	if err != nil {
+		return "", DeviceInfo{}, err
+	}
+	resp.Body.Close()
+
+	id := resp.Header.Get(HeaderClientID)
+	if id == "" {
+		return "", DeviceInfo{}, fmt.Errorf("%s did not tell its client ID", address)
+	}
+	return id, DeviceInfoFrom(resp.Header), nil
+}
@@ -0,0 +1,134 @@
+package pairing
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/fsutil"
+	"example.com/web-service/internal/logger"
+)
+
+const peersFile = "peers.json"
+
+// Peer is a paired device, the key shared with it and its pinned certificate.
+type Peer struct {
+	ClientID string `json:"clientId"`
+	DeviceInfo
+	Key             []byte    `json:"key"`
+	CertFingerprint []byte    `json:"certFingerprint"`
+	PairedAt        time.Time `json:"pairedAt"`
+}
+
+// Keystore holds the identity of one agent instance: its certificate, the peers
+// paired with it and their keys, the peers discovered around it and the pairing
+// in progress.
+type Keystore struct {
+	dataDir  string
+	clientID string
+	self     DeviceInfo
+
+	peersMu sync.RWMutex
+	peers   map[string]Peer // map[ClientID]Peer
+
+	discoveredMu sync.Mutex
+	discovered   map[string]sighting // map[ClientID]sighting
+
+	certMu      sync.Mutex
+	certificate *tls.Certificate
+
+	clientsMu   sync.Mutex
+	httpClients map[string]*http.Client // map[ClientID]*http.Client
+
+	sessionMu sync.Mutex
+	session   *pinSession
+
+	noncesMu sync.Mutex
+	nonces   map[string]time.Time // Seen nonces, kept until they fall out of the skew window
+}
+
+// NewKeystore reads the paired peers from the data directory of cfg.
+func NewKeystore(cfg *config.Config) *Keystore {
+	k := &Keystore{
+		dataDir:     cfg.DataDir,
+		clientID:    cfg.ClientID,
+		self:        DeviceInfo{DeviceName: cfg.DeviceName, Platform: cfg.Platform},
+		peers:       make(map[string]Peer),
+		discovered:  make(map[string]sighting),
+		httpClients: make(map[string]*http.Client),
+		nonces:      make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(filepath.Join(k.dataDir, peersFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("Pairing: Failed to read paired peers: %v", err)
+		}
+		return k
+	}
+
+	var list []Peer
+	if err := json.Unmarshal(data, &list); err != nil {
+		logger.Errorf("Pairing: Failed to parse paired peers: %v", err)
+		return k
+	}
+
+	for _, peer := range list {
+		k.peers[peer.ClientID] = peer
+	}
+	log.Printf("Pairing: Loaded %d paired peers", len(k.peers))
+	return k
+}
+
+// ClientID returns the client ID of this instance.
+func (k *Keystore) ClientID() string {
+	return k.clientID
+}
+
+// IsPaired reports whether a key is shared with the given client.
+func (k *Keystore) IsPaired(clientID string) bool {
+	_, ok := k.keyFor(clientID)
+	return ok
+}
+
+func (k *Keystore) keyFor(clientID string) ([]byte, bool) {
+	k.peersMu.RLock()
+	defer k.peersMu.RUnlock()
+	peer, ok := k.peers[clientID]
+	return peer.Key, ok
+}
+
+func (k *Keystore) addPeer(clientID string, info DeviceInfo, key, certFingerprint []byte) {
+	k.clientsMu.Lock()
+	delete(k.httpClients, clientID)
+	k.clientsMu.Unlock()
+
+	k.peersMu.Lock()
+	defer k.peersMu.Unlock()
+	k.peers[clientID] = Peer{ClientID: clientID, DeviceInfo: info, Key: key, CertFingerprint: certFingerprint, PairedAt: time.Now()}
+	k.saveLocked()
+	log.Printf("Pairing: Paired with %s (%s)", info.DeviceName, clientID)
+}
+
+func (k *Keystore) saveLocked() {
+	list := make([]Peer, 0, len(k.peers))
+	for _, peer := range k.peers {
+		list = append(list, peer)
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		logger.Errorf("Pairing: Failed to marshal paired peers: %v", err)
+		return
+	}
+
+	if err := fsutil.WriteFileAtomic(filepath.Join(k.dataDir, peersFile), data, 0600); err != nil {
+		logger.Errorf("Pairing: Failed to save paired peers: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package pairing
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"example.com/web-service/internal/logger"
+)
+
+const (
+	PinTimeout     = 2 * time.Minute
+	MaxPinAttempts = 3
+	keySize        = 32
+)
+
+var (
+	ErrNoPairing  = errors.New("no pairing in progress")
+	ErrInvalidPin = errors.New("invalid PIN")
+)
+
+// Request is sent by the device the PIN was entered on to the device showing it.
+// Both sides run SPAKE2 with the PIN, binding their client IDs and certificate
+// fingerprints into the transcript the shared key is derived from.
+type Request struct {
+	ClientID string `json:"clientId"`
+	DeviceInfo
+	Message         []byte `json:"message"` // SPAKE2 share masked with the PIN
+	CertFingerprint []byte `json:"certFingerprint"`
+}
+
+// Response is the reply of the device showing the PIN. Confirm proves it derived the key.
+type Response struct {
+	ClientID string `json:"clientId"`
+	DeviceInfo
+	Message         []byte `json:"message"`
+	CertFingerprint []byte `json:"certFingerprint"`
+	Confirm         []byte `json:"confirm"`
+}
+
+// Confirmation completes the pairing: the joiner proves it derived the same key,
+// and only then does the device showing the PIN store it.
+type Confirmation struct {
+	ClientID string `json:"clientId"`
+	Confirm  []byte `json:"confirm"`
+}
+
+type pinSession struct {
+	pin      string
+	expires  time.Time
+	attempts int
+	pending  *pendingPeer // Last exchange, waiting for its confirmation
+}
+
+type pendingPeer struct {
+	clientID    string
+	info        DeviceInfo
+	fingerprint []byte
+	key         []byte
+	confirm     []byte
+}
+
+// StartPairing creates a new one-time PIN to be shown to the user, replacing any previous one.
+func (k *Keystore) StartPairing() (string, time.Time) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	pin := fmt.Sprintf("%06d", n.Int64())
+
+	k.sessionMu.Lock()
+	defer k.sessionMu.Unlock()
+	k.session = &pinSession{pin: pin, expires: time.Now().Add(PinTimeout)}
+	log.Printf("Pairing: PIN created, valid until %s", k.session.expires.Format(time.RFC3339))
+	return pin, k.session.expires
+}
+
+// Respond answers a pairing request with our share of the exchange. Every request counts
+// as an attempt at the PIN, since a wrong one only shows when the confirmation fails.
+func (k *Keystore) Respond(req Request) (Response, error) {
+	k.sessionMu.Lock()
+	defer k.sessionMu.Unlock()
+
+	if k.session == nil || time.Now().After(k.session.expires) {
+		k.session = nil
+		return Response{}, ErrNoPairing
+	}
+	k.session.attempts++
+	if k.session.attempts > MaxPinAttempts {
+		logger.Warnf("Pairing: Too many attempts, pairing cancelled")
+		k.session = nil
+		return Response{}, ErrNoPairing
+	}
+
+	exchange, err := newSpake2(k.session.pin, spakeN)
+	if err != nil {
+		return Response{}, err
+	}
+	shared, err := exchange.finish(req.Message, spakeM)
+	if err != nil {
+		return Response{}, err
+	}
+
+	fingerprint, err := k.Fingerprint()
+	if err != nil {
+		return Response{}, err
+	}
+
+	resp := Response{
+		ClientID:        k.clientID,
+		DeviceInfo:      k.self,
+		Message:         exchange.message,
+		CertFingerprint: fingerprint,
+	}
+	key, requestConfirm, responseConfirm, err := sessionKeys(req, resp, shared, exchange.scalar())
+	if err != nil {
+		return Response{}, err
+	}
+	resp.Confirm = responseConfirm
+
+	k.session.pending = &pendingPeer{
+		clientID:    req.ClientID,
+		info:        req.DeviceInfo,
+		fingerprint: req.CertFingerprint,
+		key:         key,
+		confirm:     requestConfirm,
+	}
+	return resp, nil
+}
+
+// Confirm checks the joiner's confirmation of the last exchange and stores the key.
+func (k *Keystore) Confirm(c Confirmation) error {
+	k.sessionMu.Lock()
+	defer k.sessionMu.Unlock()
+
+	if k.session == nil || time.Now().After(k.session.expires) {
+		k.session = nil
+		return ErrNoPairing
+	}
+	pending := k.session.pending
+	if pending == nil || pending.clientID != c.ClientID {
+		return ErrNoPairing
+	}
+	k.session.pending = nil
+	if !hmac.Equal(pending.confirm, c.Confirm) {
+		if k.session.attempts >= MaxPinAttempts {
+			logger.Warnf("Pairing: Too many wrong PINs, pairing cancelled")
+			k.session = nil
+		}
+		return ErrInvalidPin
+	}
+	// The PIN is single use
+	k.session = nil
+
+	k.addPeer(pending.clientID, pending.info, pending.key, pending.fingerprint)
+	return nil
+}
+
+// Join pairs with the device at address using the PIN it displays, and returns its client ID.
+func (k *Keystore) Join(address, pin string) (string, error) {
+	exchange, err := newSpake2(pin, spakeM)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, err := k.Fingerprint()
+	if err != nil {
+		return "", err
+	}
+
+	req := Request{
+		ClientID:        k.clientID,
+		DeviceInfo:      k.self,
+		Message:         exchange.message,
+		CertFingerprint: fingerprint,
+	}
+
+	// The peer's certificate is not known yet. It is accepted here and checked
+	// against the fingerprint bound into the exchange below.
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13},
+	}}
+	var resp Response
+	httpResp, err := post(client, address, "/pair", req, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	if httpResp.TLS == nil || len(httpResp.TLS.PeerCertificates) == 0 {
+		return "", errors.New("peer did not use TLS")
+	}
+	if presented := sha256.Sum256(httpResp.TLS.PeerCertificates[0].Raw); !hmac.Equal(presented[:], resp.CertFingerprint) {
+		return "", errors.New("peer certificate does not match its announced fingerprint")
+	}
+
+	shared, err := exchange.finish(resp.Message, spakeN)
+	if err != nil {
+		return "", err
+	}
+	key, requestConfirm, responseConfirm, err := sessionKeys(req, resp, shared, exchange.scalar())
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(responseConfirm, resp.Confirm) {
+		return "", ErrInvalidPin
+	}
+
+	// From here on the peer's certificate is known
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: pinnedTLSConfig(resp.CertFingerprint)}}
+	if _, err := post(client, address, "/pair/confirm", Confirmation{ClientID: k.clientID, Confirm: requestConfirm}, nil); err != nil {
+		return "", err
+	}
+
+	k.addPeer(resp.ClientID, resp.DeviceInfo, key, resp.CertFingerprint)
+	return resp.ClientID, nil
+}
+
+// post sends a pairing message and decodes the reply into out, if given.
+func post(client *http.Client, address, path string, in, out interface{}) (*http.Response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	u := url.URL{Scheme: "https", Host: address, Path: path}
+	httpResp, err := client.Post(u.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pairing rejected: %s", httpResp.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(httpResp.Body).Decode(out); err != nil {
+			return nil, err
+		}
+	}
+	return httpResp, nil
+}
+
+// sessionKeys derives the peer key and both confirmation MACs from the transcript of the
+// exchange, which binds both identities, certificates and shares to the shared element.
+func sessionKeys(req Request, resp Response, shared, w []byte) (key, requestConfirm, responseConfirm []byte, err error) {
+	transcript := sha256.New()
+	for _, part := range [][]byte{
+		[]byte(req.ClientID), []byte(resp.ClientID),
+		req.CertFingerprint, resp.CertFingerprint,
+		req.Message, resp.Message,
+		shared, w,
+	} {
+		transcript.Write(binary.BigEndian.AppendUint64(nil, uint64(len(part))))
+		transcript.Write(part)
+	}
+	secret := transcript.Sum(nil)
+
+	if key, err = hkdf.Key(sha256.New, secret, nil, "pasteflow-peer-key", keySize); err != nil {
+		return
+	}
+	confirm := func(label string) ([]byte, error) {
+		confirmKey, err := hkdf.Key(sha256.New, secret, nil, "pasteflow-pair-confirm-"+label, keySize)
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(sha256.New, confirmKey)
+		mac.Write(secret)
+		return mac.Sum(nil), nil
+	}
+	if requestConfirm, err = confirm("request"); err != nil {
+		return
+	}
+	responseConfirm, err = confirm("response")
+	return
+}
@@ -0,0 +1,109 @@
+package pairing
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// SPAKE2 (RFC 9382) over the 2048-bit MODP group of RFC 3526. Each side masks an
+// ephemeral Diffie-Hellman share with the PIN, so a wrong PIN only yields an unrelated
+// key: every exchange tests a single guess, and a recorded one cannot be brute-forced
+// offline.
+
+const groupPrime = `
+	FFFFFFFF FFFFFFFF C90FDAA2 2168C234 C4C6628B 80DC1CD1
+	29024E08 8A67CC74 020BBEA6 3B139B22 514A0879 8E3404DD
+	EF9519B3 CD3A431B 302B0A6D F25F1437 4FE1356D 6D51C245
+	E485B576 625E7EC6 F44C42E9 A637ED6B 0BFF5CB6 F406B7ED
+	EE386BFB 5A899FA5 AE9F2411 7C4B1FE6 49286651 ECE45B3D
+	C2007CB8 A163BF05 98DA4836 1C55D39A 69163FA8 FD24CF5F
+	83655D23 DCA3AD96 1C62F356 208552BB 9ED52907 7096966D
+	670C354E 4ABC9804 F1746C08 CA18217C 32905E46 2E36CE3B
+	E39E772C 180E8603 9B2783A2 EC07A28F B5C55DF0 6F4C52C9
+	DE2BCBF6 95581718 3995497C EA956AE5 15D22618 98FA0510
+	15728E5A 8AACAA68 FFFFFFFF FFFFFFFF`
+
+// elementSize is the length of an encoded group element
+const elementSize = 256
+
+var (
+	groupP, _ = new(big.Int).SetString(strings.Join(strings.Fields(groupPrime), ""), 16)
+	// groupQ is the order of the subgroup of squares, which 2 generates since p is a safe prime ≡ 7 mod 8
+	groupQ = new(big.Int).Rsh(groupP, 1)
+	groupG = big.NewInt(2)
+
+	// The masks of the joiner and the responder. Nobody knows their discrete logarithms.
+	spakeM = hashToGroup("pasteflow-spake2-M")
+	spakeN = hashToGroup("pasteflow-spake2-N")
+
+	errInvalidElement = errors.New("invalid key share")
+)
+
+// spake2 is one side of an exchange.
+type spake2 struct {
+	w       *big.Int // The PIN as a scalar
+	secret  *big.Int
+	message []byte // Our masked share, sent to the peer
+}
+
+// newSpake2 starts an exchange; the joiner masks with spakeM, the responder with spakeN.
+func newSpake2(pin string, mask *big.Int) (*spake2, error) {
+	secret, err := rand.Int(rand.Reader, new(big.Int).Sub(groupQ, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	secret.Add(secret, big.NewInt(1))
+
+	w, err := pinScalar(pin)
+	if err != nil {
+		return nil, err
+	}
+	share := new(big.Int).Exp(groupG, secret, groupP)
+	share.Mul(share, new(big.Int).Exp(mask, w, groupP)).Mod(share, groupP)
+	return &spake2{w: w, secret: secret, message: share.FillBytes(make([]byte, elementSize))}, nil
+}
+
+// finish removes the peer's mask from its share and returns the shared element,
+// encoded. It rejects shares outside the subgroup, which could leak the secret.
+func (s *spake2) finish(peerMessage []byte, peerMask *big.Int) ([]byte, error) {
+	share := new(big.Int).SetBytes(peerMessage)
+	if len(peerMessage) != elementSize || share.Cmp(big.NewInt(1)) <= 0 || share.Cmp(new(big.Int).Sub(groupP, big.NewInt(1))) >= 0 ||
+		new(big.Int).Exp(share, groupQ, groupP).Cmp(big.NewInt(1)) != 0 {
+		return nil, errInvalidElement
+	}
+
+	// Elements have order q, so mask^(q-w) is the inverse of mask^w
+	share.Mul(share, new(big.Int).Exp(peerMask, new(big.Int).Sub(groupQ, s.w), groupP)).Mod(share, groupP)
+	shared := share.Exp(share, s.secret, groupP)
+	if shared.Cmp(big.NewInt(1)) == 0 {
+		return nil, errInvalidElement
+	}
+	return shared.FillBytes(make([]byte, elementSize)), nil
+}
+
+// scalar encodes w for the transcript.
+func (s *spake2) scalar() []byte {
+	return s.w.FillBytes(make([]byte, elementSize))
+}
+
+func pinScalar(pin string) (*big.Int, error) {
+	b, err := hkdf.Key(sha256.New, []byte(pin), nil, "pasteflow-spake2-pin", elementSize+32)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(b), groupQ), nil
+}
+
+// hashToGroup maps a label to a square mod p, an element of the subgroup.
+func hashToGroup(label string) *big.Int {
+	b, err := hkdf.Key(sha256.New, []byte(label), nil, "pasteflow-spake2-element", elementSize+32)
+	if err != nil {
+		panic(err)
+	}
+	e := new(big.Int).Mod(new(big.Int).SetBytes(b), groupP)
+	return e.Mul(e, e).Mod(e, groupP)
+}
@@ -0,0 +1,146 @@
+package pairing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"math/big"
+	"testing"
+
+	"example.com/web-service/internal/config"
+)
+
+func newTestKeystore(t *testing.T, clientID string) *Keystore {
+	cfg := config.Default()
+	cfg.ClientID = clientID
+	cfg.DeviceName = "device " + clientID
+	cfg.DataDir = t.TempDir()
+	return NewKeystore(cfg)
+}
+
+// join runs the joiner's side of Join against k without HTTP. It returns the key the
+// joiner derived and the error of whichever side noticed a wrong PIN first.
+func join(k *Keystore, clientID, pin string) ([]byte, error) {
+	exchange, err := newSpake2(pin, spakeM)
+	if err != nil {
+		return nil, err
+	}
+	req := Request{ClientID: clientID, Message: exchange.message, CertFingerprint: []byte("joiner certificate")}
+	resp, err := k.Respond(req)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := exchange.finish(resp.Message, spakeN)
+	if err != nil {
+		return nil, err
+	}
+	key, requestConfirm, responseConfirm, err := sessionKeys(req, resp, shared, exchange.scalar())
+	if err != nil {
+		return nil, err
+	}
+	// A joiner with the wrong PIN confirms anyway, as an attacker would
+	confirmErr := k.Confirm(Confirmation{ClientID: clientID, Confirm: requestConfirm})
+	if !hmac.Equal(responseConfirm, resp.Confirm) {
+		return nil, ErrInvalidPin
+	}
+	return key, confirmErr
+}
+
+func TestSpake2MatchingPinsAgreeOnKey(t *testing.T) {
+	k := newTestKeystore(t, "responder")
+	pin, _ := k.StartPairing()
+
+	key, err := join(k, "joiner", pin)
+	if err != nil {
+		t.Fatalf("pairing failed: %v", err)
+	}
+	stored, ok := k.keyFor("joiner")
+	if !ok {
+		t.Fatal("responder did not store the joiner")
+	}
+	if !bytes.Equal(key, stored) {
+		t.Error("both sides derived different keys")
+	}
+
+	// The PIN is single use
+	if _, err := join(k, "other", pin); !errors.Is(err, ErrNoPairing) {
+		t.Errorf("second pairing with the same PIN returned %v, want ErrNoPairing", err)
+	}
+}
+
+func TestSpake2WrongPinFailsConfirmation(t *testing.T) {
+	k := newTestKeystore(t, "responder")
+	pin, _ := k.StartPairing()
+
+	wrong := "000000"
+	if pin == wrong {
+		wrong = "000001"
+	}
+	if _, err := join(k, "joiner", wrong); !errors.Is(err, ErrInvalidPin) {
+		t.Fatalf("pairing with a wrong PIN returned %v, want ErrInvalidPin", err)
+	}
+	if k.IsPaired("joiner") {
+		t.Error("responder paired with a wrong PIN")
+	}
+
+	// The joiner can try again while attempts are left
+	if _, err := join(k, "joiner", pin); err != nil {
+		t.Errorf("pairing with the right PIN after a wrong one failed: %v", err)
+	}
+}
+
+func TestSpake2AttemptLimitInvalidatesPin(t *testing.T) {
+	k := newTestKeystore(t, "responder")
+	pin, _ := k.StartPairing()
+
+	wrong := "000000"
+	if pin == wrong {
+		wrong = "000001"
+	}
+	for i := 0; i < MaxPinAttempts; i++ {
+		if _, err := join(k, "joiner", wrong); !errors.Is(err, ErrInvalidPin) {
+			t.Fatalf("attempt %d returned %v, want ErrInvalidPin", i+1, err)
+		}
+	}
+	if _, err := join(k, "joiner", pin); !errors.Is(err, ErrNoPairing) {
+		t.Errorf("pairing with the right PIN after %d wrong ones returned %v, want ErrNoPairing", MaxPinAttempts, err)
+	}
+	if k.IsPaired("joiner") {
+		t.Error("responder paired after the PIN was invalidated")
+	}
+}
+
+func TestSpake2RejectsInvalidElements(t *testing.T) {
+	exchange, err := newSpake2("123456", spakeN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(x *big.Int) []byte { return x.FillBytes(make([]byte, elementSize)) }
+	one := big.NewInt(1)
+	pMinus1 := new(big.Int).Sub(groupP, one)
+
+	// Masking the identity with the PIN yields exactly the PIN's mask, which would make
+	// the shared element 1 after unmasking
+	w, err := pinScalar("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	maskOnly := new(big.Int).Exp(spakeM, w, groupP)
+
+	tests := map[string][]byte{
+		"zero":         encode(big.NewInt(0)),
+		"identity":     encode(one),
+		"p-1":          encode(pMinus1),
+		"p":            groupP.Bytes(),
+		"too short":    encode(groupG)[1:],
+		"too long":     append([]byte{0}, encode(groupG)...),
+		"non-square":   encode(new(big.Int).Sub(groupP, groupG)),
+		"masked unity": encode(maskOnly),
+	}
+	for name, message := range tests {
+		if _, err := exchange.finish(message, spakeM); !errors.Is(err, errInvalidElement) {
+			t.Errorf("%s: finish returned %v, want errInvalidElement", name, err)
+		}
+	}
+}
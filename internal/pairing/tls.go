@@ -0,0 +1,171 @@
+package pairing
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"example.com/web-service/internal/logger"
+)
+
+const (
+	certFile = "cert.pem"
+	keyFile  = "key.pem"
+)
+
+// Certificate returns this device's self-signed TLS certificate, creating and persisting it on first use.
+// Peers pin its fingerprint during pairing.
+func (k *Keystore) Certificate() (*tls.Certificate, error) {
+	k.certMu.Lock()
+	defer k.certMu.Unlock()
+	if k.certificate != nil {
+		return k.certificate, nil
+	}
+
+	certPath := filepath.Join(k.dataDir, certFile)
+	keyPath := filepath.Join(k.dataDir, keyFile)
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Pairing: Failed to load certificate, creating a new one: %v", err)
+		}
+		if cert, err = createCertificate(certPath, keyPath); err != nil {
+			return nil, err
+		}
+		log.Printf("Pairing: Created new TLS certificate")
+	}
+
+	k.certificate = &cert
+	return k.certificate, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of this device's certificate.
+func (k *Keystore) Fingerprint() ([]byte, error) {
+	cert, err := k.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return sum[:], nil
+}
+
+func createCertificate(certPath, keyPath string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "PasteFlow"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(20, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// ServerTLSConfig returns the configuration for the HTTP/WS listener.
+func (k *Keystore) ServerTLSConfig() (*tls.Config, error) {
+	cert, err := k.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// ClientTLSConfig returns a configuration that only accepts the certificate pinned for peerID.
+func (k *Keystore) ClientTLSConfig(peerID string) (*tls.Config, error) {
+	k.peersMu.RLock()
+	peer, ok := k.peers[peerID]
+	k.peersMu.RUnlock()
+	if !ok {
+		return nil, ErrNotPaired
+	}
+	if len(peer.CertFingerprint) == 0 {
+		return nil, fmt.Errorf("no pinned certificate for %s, pair again", peerID)
+	}
+	return pinnedTLSConfig(peer.CertFingerprint), nil
+}
+
+// HTTPClient returns an HTTPS client for requests to the paired peer peerID.
+func (k *Keystore) HTTPClient(peerID string) (*http.Client, error) {
+	k.clientsMu.Lock()
+	defer k.clientsMu.Unlock()
+	if client, ok := k.httpClients[peerID]; ok {
+		return client, nil
+	}
+
+	tlsConfig, err := k.ClientTLSConfig(peerID)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}
+	k.httpClients[peerID] = client
+	return client, nil
+}
+
+// pinnedTLSConfig accepts exactly the certificate with the given fingerprint.
+// Chain and host name verification are replaced by the pin, as certificates are self-signed.
+func pinnedTLSConfig(fingerprint []byte) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("peer presented no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], fingerprint) {
+				return errors.New("peer certificate does not match the pinned fingerprint")
+			}
+			return nil
+		},
+	}
+}
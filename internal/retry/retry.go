@@ -0,0 +1,37 @@
+package retry
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// jitter is the spread of Jitter, ±20%
+const jitter = 0.2
+
+// Jitter spreads d by up to ±20%, so peers that started or dropped together do not stay in step.
+func Jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// Backoff yields delays doubling from initial up to limit. It is not safe for concurrent use.
+type Backoff struct {
+	initial time.Duration
+	limit   time.Duration
+	next    time.Duration
+}
+
+func NewBackoff(initial, limit time.Duration) *Backoff {
+	return &Backoff{initial: initial, limit: max(limit, initial), next: initial}
+}
+
+// Delay returns the next delay with jitter applied.
+func (b *Backoff) Delay() time.Duration {
+	d := b.next
+	b.next = min(b.next*2, b.limit)
+	return Jitter(d)
+}
+
+// Reset starts again from the initial delay, e.g. after a success.
+func (b *Backoff) Reset() {
+	b.next = b.initial
+}
@@ -3,31 +3,88 @@ package server
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 
 	"example.com/web-service/internal/api"
 	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/jobs"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/store"
 	"example.com/web-service/internal/websocket"
 )
 
-func StartHTTP(hub *websocket.Hub, manager *websocket.ClientManager) {
+// NewHandler routes the local API, the peer endpoints and the WebSocket of one agent instance.
+func NewHandler(cfg *config.Config, keys *pairing.Keystore, clipboard *store.Store, hub *websocket.Hub, manager *websocket.ClientManager) http.Handler {
+	mux := http.NewServeMux()
+	pasteJobs := jobs.NewRegistry()
+
 	// Setup HTTP routes
-	http.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Received request: /hello")
+		keys.AddIdentityHeaders(w.Header())
 		fmt.Fprintf(w, "hello")
 	})
-	http.HandleFunc("/api/copyFileInfoToCloud", api.HandleCopyFileInfoToCloud(hub, manager))
-	http.HandleFunc("/api/pasteFileFromCloud", api.HandlePasteFileFromCloud)
-	http.HandleFunc("/download", api.HandleDownload)
-	http.HandleFunc("/udp/send", api.HandleUDPSend)
+	// Endpoints of the local agent
+	mux.HandleFunc("/api/copyFileInfoToCloud", api.LocalOnly(cfg, api.HandleCopyFileInfoToCloud(cfg, clipboard, hub, manager)))
+	mux.HandleFunc("/api/pasteFileFromCloud", api.LocalOnly(cfg, api.HandlePasteFileFromCloud(cfg, keys, clipboard, hub, pasteJobs)))
+	mux.HandleFunc("/api/jobs/{id}", api.LocalOnly(cfg, api.HandleGetJob(pasteJobs)))
+	mux.HandleFunc("/api/copyContent", api.LocalOnly(cfg, api.HandleCopyContent(cfg, clipboard, hub, manager)))
+	mux.HandleFunc("/api/clipboard", api.LocalOnly(cfg, api.HandleGetClipboard(keys, clipboard)))
+	mux.HandleFunc("/api/history", api.LocalOnly(cfg, api.HandleGetHistory(clipboard)))
+	mux.HandleFunc("/api/peers", api.LocalOnly(cfg, api.HandlePeers(keys, manager)))
+	mux.HandleFunc("/api/peers/{id}", api.LocalOnly(cfg, api.HandleDeletePeer(manager)))
+	mux.HandleFunc("/api/peers/{id}/call", api.LocalOnly(cfg, api.HandlePeerCall(manager)))
+	mux.HandleFunc("/udp/send", api.LocalOnly(cfg, api.HandleUDPSend))
+
+	// Endpoints of paired peers
+	mux.HandleFunc("/download", api.RequirePairedPeer(keys, api.HandleDownload(clipboard)))
+	mux.HandleFunc("/content", api.RequirePairedPeer(keys, api.HandleContent(clipboard)))
+
+	// Pairing routes
+	mux.HandleFunc("/pair", api.HandlePair(keys))
+	mux.HandleFunc("/pair/confirm", api.HandlePairConfirm(keys))
+	mux.HandleFunc("/api/pairing/start", api.LocalOnly(cfg, api.HandlePairingStart(keys)))
+	mux.HandleFunc("/api/pairing/join", api.LocalOnly(cfg, api.HandlePairingJoin(keys, manager)))
+	mux.HandleFunc("/api/pairing/peers", api.LocalOnly(cfg, api.HandlePairingPeers(keys)))
 
 	// WebSocket route
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		websocket.ServeWs(hub, w, r)
 	})
 
-	log.Printf("Server running at http://localhost:%d", config.HttpPort)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", config.HttpPort), nil); err != nil {
-		log.Fatal(err)
+	return requireTLS(mux)
+}
+
+// StartHTTP serves NewHandler on the HTTP port, over TLS for peers and plain HTTP for the local agent.
+func StartHTTP(cfg *config.Config, keys *pairing.Keystore, clipboard *store.Store, hub *websocket.Hub, manager *websocket.ClientManager) {
+	tlsConfig, err := keys.ServerTLSConfig()
+	if err != nil {
+		logger.Fatalf("Failed to load TLS certificate: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(cfg.BindAddress, strconv.Itoa(cfg.HttpPort)))
+	if err != nil {
+		logger.Fatalf("HTTP server error: %v", err)
+	}
+
+	log.Printf("Server running at http://localhost:%d (TLS for peers)", cfg.HttpPort)
+	srv := &http.Server{Handler: NewHandler(cfg, keys, clipboard, hub, manager)}
+	if err := srv.Serve(newSniffListener(ln, tlsConfig)); err != nil {
+		logger.Fatalf("HTTP server error: %v", err)
 	}
 }
+
+// requireTLS rejects plain HTTP requests from other machines, so peer traffic is always encrypted.
+func requireTLS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil && !netutil.IsLoopback(r.RemoteAddr) && r.URL.Path != "/hello" {
+			http.Error(w, "TLS required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/models"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/store"
+	"example.com/web-service/internal/websocket"
+)
+
+type testServer struct {
+	keys      *pairing.Keystore
+	clipboard *store.Store
+	addr      string
+}
+
+// startTestServer serves an agent instance with its own data directory on a loopback port.
+func startTestServer(t *testing.T, clientID string) *testServer {
+	t.Helper()
+	// The history is written in the background, which t.TempDir's cleanup could trip over
+	dataDir, err := os.MkdirTemp("", "pasteflow-"+clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+
+	cfg := config.Default()
+	cfg.ClientID = clientID
+	cfg.DeviceName = "device " + clientID
+	cfg.DataDir = dataDir
+	keys := pairing.NewKeystore(cfg)
+	clipboard := store.New(cfg)
+	hub := websocket.NewHub(cfg, keys, clipboard)
+
+	tlsConfig, err := keys.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: NewHandler(cfg, keys, clipboard, hub, nil)}
+	go srv.Serve(newSniffListener(ln, tlsConfig))
+	t.Cleanup(func() { srv.Close() })
+
+	return &testServer{keys: keys, clipboard: clipboard, addr: ln.Addr().String()}
+}
+
+// download fetches a file announced by peerID from addr, as the paste of from does.
+func (from *testServer) download(addr, peerID, token string) (*http.Response, error) {
+	u := url.URL{Scheme: "https", Host: addr, Path: "/download", RawQuery: url.Values{"token": {token}}.Encode()}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := from.keys.SignRequest(req.Header, peerID, req.Method, req.URL.RequestURI()); err != nil {
+		return nil, err
+	}
+	client, err := from.keys.HTTPClient(peerID)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+func TestDownloadPinsPairedCertificate(t *testing.T) {
+	a := startTestServer(t, "a")
+	b := startTestServer(t, "b")
+
+	pin, _ := a.keys.StartPairing()
+	id, err := b.keys.Join(a.addr, pin)
+	if err != nil {
+		t.Fatalf("pairing failed: %v", err)
+	}
+	if id != "a" || !a.keys.IsPaired("b") || !b.keys.IsPaired("a") {
+		t.Fatalf("pairing returned %q; a paired with b: %v, b paired with a: %v", id, a.keys.IsPaired("b"), b.keys.IsPaired("a"))
+	}
+
+	path := filepath.Join(t.TempDir(), "note.txt")
+	if err := os.WriteFile(path, []byte("copied on a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	entry := a.clipboard.StoreLocalFiles([]models.FileData{{Path: path, Name: "note.txt"}}, "127.0.0.1", 0, "a")
+	token := entry.Files[0].Token
+
+	resp, err := b.download(a.addr, "a", token)
+	if err != nil {
+		t.Fatalf("download from the paired peer failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "copied on a" {
+		t.Fatalf("download returned %s: %q", resp.Status, body)
+	}
+
+	// Another device at the address we expect a to be, e.g. after spoofed discovery,
+	// has a different certificate and must not be talked to
+	impostor := startTestServer(t, "a")
+	resp, err = b.download(impostor.addr, "a", token)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("download from a server with another certificate succeeded: %s", resp.Status)
+	}
+	if !strings.Contains(err.Error(), "pinned fingerprint") {
+		t.Errorf("download from a server with another certificate failed for another reason: %v", err)
+	}
+}
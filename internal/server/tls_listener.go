@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/retry"
+)
+
+const (
+	// sniffTimeout bounds how long a new connection may take to send its first byte
+	sniffTimeout = 10 * time.Second
+	// tlsHandshakeRecord is the first byte of every TLS connection
+	tlsHandshakeRecord = 0x16
+
+	// Temporary accept errors are retried after acceptRetryMin, doubling up to acceptRetryMax
+	acceptRetryMin = 5 * time.Millisecond
+	acceptRetryMax = time.Second
+)
+
+// sniffListener serves TLS and plain HTTP on the same port. Peers always use TLS,
+// the local agent talks plain HTTP over loopback.
+type sniffListener struct {
+	net.Listener
+	tlsConfig *tls.Config
+	conns     chan net.Conn
+	failed    chan struct{} // Closed when accepting failed for good, with err set
+	err       error
+	done      chan struct{} // Closed by Close
+	closeOnce sync.Once
+}
+
+func newSniffListener(inner net.Listener, tlsConfig *tls.Config) *sniffListener {
+	l := &sniffListener{
+		Listener:  inner,
+		tlsConfig: tlsConfig,
+		conns:     make(chan net.Conn),
+		failed:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// acceptLoop accepts connections until the listener fails or is closed. Temporary
+// errors, e.g. running out of file descriptors, are retried after a backoff.
+func (l *sniffListener) acceptLoop() {
+	backoff := retry.NewBackoff(acceptRetryMin, acceptRetryMax)
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				delay := backoff.Delay()
+				logger.Warnf("HTTP listener error: %v; retrying in %v", err, delay)
+				select {
+				case <-time.After(delay):
+					continue
+				case <-l.done:
+					return
+				}
+			}
+			l.err = err
+			close(l.failed)
+			return
+		}
+		backoff.Reset()
+		// Sniff in the background so one slow client does not block others
+		go l.sniff(conn)
+	}
+}
+
+func (l *sniffListener) sniff(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	var c net.Conn = &peekedConn{Conn: conn, reader: reader}
+	if first[0] == tlsHandshakeRecord {
+		// http.Server detects *tls.Conn to run the handshake and fill in Request.TLS
+		c = tls.Server(c, l.tlsConfig)
+	}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		// Nobody accepts anymore
+		c.Close()
+	}
+}
+
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-l.failed:
+		select {
+		case <-l.done:
+			// Closing the inner listener ended acceptLoop
+			return nil, net.ErrClosed
+		default:
+		}
+		logger.Errorf("HTTP listener error: %v", l.err)
+		return nil, l.err
+	}
+}
+
+// Close stops accepting and releases connections still being sniffed.
+func (l *sniffListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// peekedConn replays the bytes buffered while sniffing.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// temporaryError is what Accept returns when the process runs out of file descriptors.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return syscall.EMFILE.Error() }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails its first Accept calls with a temporary error.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+func TestSniffListenerRetriesTemporaryErrors(t *testing.T) {
+	ln := listen(t)
+	l := newSniffListener(&flakyListener{Listener: ln, failures: 3}, nil)
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed after temporary errors: %v", err)
+	}
+	accepted.Close()
+}
+
+func TestSniffListenerCloseReleasesPendingConnections(t *testing.T) {
+	ln := listen(t)
+	l := newSniffListener(ln, nil)
+
+	// A connection that was sniffed but never accepted
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Close returned %v, want net.ErrClosed", err)
+	}
+
+	// The sniffing goroutine closes the connection instead of waiting for an Accept
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("pending connection was not closed: %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
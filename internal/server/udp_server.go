@@ -5,26 +5,34 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"time"
 
 	"example.com/web-service/internal/config"
-	"example.com/web-service/internal/websocket"
+	"example.com/web-service/internal/discovery"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
 )
 
 type DiscoveryMessage struct {
-	ClientID string `json:"clientId"`
-	Port     int    `json:"port"`  // HTTP/WS Port
-	WSUrl    string `json:"wsUrl"` // Optional, if provided directly
+	ClientID   string `json:"clientId"`
+	DeviceName string `json:"deviceName,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	Port       int    `json:"port"`          // HTTP/WS Port
+	WSUrl      string `json:"wsUrl"`         // Optional, if provided directly
+	TTL        int    `json:"ttl,omitempty"` // Seconds the announcement is valid
 }
 
-// StartUDP starts the UDP server.
-func StartUDP(manager *websocket.ClientManager) {
-	addr := net.UDPAddr{
-		Port: config.UdpPort,
-		IP:   net.ParseIP("0.0.0.0"),
+// StartUDP starts the UDP server. Discovery broadcasts are reported to disc.
+func StartUDP(cfg *config.Config, disc *discovery.Discovery) {
+	// An empty bind address listens on IPv4 and IPv6
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(cfg.BindAddress, strconv.Itoa(cfg.UdpPort)))
+	if err != nil {
+		logger.Fatalf("Invalid UDP bind address: %v", err)
 	}
-	conn, err := net.ListenUDP("udp", &addr)
+	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
-		log.Fatalf("UDP server error: %v", err) // Use Fatalf to exit if UDP server fails
+		logger.Fatalf("UDP server error: %v", err) // Use Fatalf to exit if UDP server fails
 	}
 	defer conn.Close()
 
@@ -34,7 +42,7 @@ func StartUDP(manager *websocket.ClientManager) {
 	for {
 		n, remoteAddr, err := conn.ReadFromUDP(buf)
 		if err != nil {
-			log.Printf("UDP read error: %v", err)
+			logger.Errorf("UDP read error: %v", err)
 			continue
 		}
 
@@ -44,11 +52,11 @@ func StartUDP(manager *websocket.ClientManager) {
 		var discoveryMsg DiscoveryMessage
 		if err := json.Unmarshal(msg, &discoveryMsg); err == nil {
 			// 1. Check if it's myself
-			if discoveryMsg.ClientID == config.ClientID {
+			if discoveryMsg.ClientID == cfg.ClientID {
 				continue
 			}
 
-			log.Printf("Received UDP message from %s: %s", remoteAddr.String(), string(msg))
+			logger.Debugf("Received UDP message from %s: %s", remoteAddr.String(), string(msg))
 
 			// 2. Determine WebSocket URL
 			var targetUrl string
@@ -56,29 +64,27 @@ func StartUDP(manager *websocket.ClientManager) {
 				targetUrl = discoveryMsg.WSUrl
 			} else if discoveryMsg.Port > 0 {
 				// Construct URL from remote IP and Port
-				// remoteAddr.IP can be IPv4 or IPv6.
-				// For IPv6, we need brackets.
+				// remoteAddr.IP can be IPv4 or IPv6; link-local IPv6 keeps its zone.
 				ip := remoteAddr.IP.String()
-				// Basic check for IPv6
-				if remoteAddr.IP.To4() == nil {
-					ip = fmt.Sprintf("[%s]", ip)
+				if remoteAddr.Zone != "" {
+					ip += "%" + remoteAddr.Zone
 				}
-				targetUrl = fmt.Sprintf("%s:%d", ip, discoveryMsg.Port)
+				targetUrl = net.JoinHostPort(ip, strconv.Itoa(discoveryMsg.Port))
 			}
 
+			// 3. Record the peer and connect if it is paired
 			if targetUrl != "" {
-				// 3. Check if already connected
-				if manager.IsConnected(discoveryMsg.ClientID) {
-					continue
-				}
-
-				log.Printf("Discovered Peer: %s (ClientID: %s)", targetUrl, discoveryMsg.ClientID)
-				manager.ConnectToCloud(targetUrl, discoveryMsg.ClientID)
+				disc.Found(discovery.Peer{
+					ClientID: discoveryMsg.ClientID,
+					Address:  targetUrl,
+					Info:     pairing.DeviceInfo{DeviceName: discoveryMsg.DeviceName, Platform: discoveryMsg.Platform},
+					TTL:      time.Duration(discoveryMsg.TTL) * time.Second,
+				})
 				continue
 			}
 		}
 
-		if remoteAddr.Port == config.UdpPort {
+		if remoteAddr.Port == cfg.UdpPort {
 			// Ignore other broadcasts that we couldn't parse or process
 			continue
 		}
@@ -87,7 +93,7 @@ func StartUDP(manager *websocket.ClientManager) {
 		response := []byte(fmt.Sprintf("Echo: %s", string(msg)))
 		_, err = conn.WriteToUDP(response, remoteAddr)
 		if err != nil {
-			log.Printf("UDP send error: %v", err)
+			logger.Errorf("UDP send error: %v", err)
 		}
 	}
 }
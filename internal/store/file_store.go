@@ -1,38 +1,264 @@
 package store
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"sync"
+	"time"
 
+	"example.com/web-service/internal/logger"
 	"example.com/web-service/internal/models"
 )
 
-var (
-	storeMu        sync.Mutex
-	fileStore      []models.FileData
-	storedIP       string
-	storedPort     int
-	fileStoreIndex int64
-	nextIndex      int64 = 1
-)
+// maxClockJump is how far a peer's clock may be ahead of ours. Clocks tick once per copy,
+// so anything beyond it is a broken or hostile peer.
+const maxClockJump = 1 << 20
+
+// Entry is one clipboard announcement in the history: either files or content.
+// Entries are ordered by (Clock, ClientID), so every peer agrees on the newest one.
+type Entry struct {
+	Index     int64                   `json:"index"`
+	Clock     uint64                  `json:"clock"`    // Lamport clock assigned by the origin
+	ClientID  string                  `json:"clientId"` // Origin of the announcement
+	IP        string                  `json:"ip"`
+	Port      int                     `json:"port"`
+	Timestamp time.Time               `json:"timestamp"`
+	Files     []models.FileData       `json:"files,omitempty"`
+	Content   []models.Representation `json:"representations,omitempty"`
+	// Local marks entries copied on this machine. Their files and content, identified by
+	// token, may be served to peers; local content keeps its full data.
+	Local bool `json:"local,omitempty"`
+	// Unfetchable marks entries relayed from an origin we are not paired with, whose files
+	// or large content we cannot download. They are listed in the history but never current.
+	Unfetchable bool `json:"unfetchable,omitempty"`
+}
+
+// Store is the clipboard history of one agent instance.
+type Store struct {
+	dataDir         string
+	historyMaxCount int
+	historyMaxAge   time.Duration
+
+	mu        sync.Mutex
+	history   []Entry // Ordered oldest first; the last entry is the current clipboard
+	nextIndex int64
+	clock     uint64 // Lamport clock, ahead of every entry seen
+
+	pending []Entry // Snapshot waiting to be written
+	dirty   bool    // pending is set
+	saving  bool    // writeHistory is running
+}
+
+// StoreLocalFiles assigns a download token to each file announced by this machine,
+// saves them as the current clipboard and returns the new entry
+func (s *Store) StoreLocalFiles(files []models.FileData, ip string, port int, clientID string) Entry {
+	for i := range files {
+		files[i].Token = newToken()
+	}
+
+	return s.addLocal(Entry{ClientID: clientID, IP: ip, Port: port, Files: files, Local: true})
+}
+
+// StoreFiles saves files announced by a peer with the origin's clock. It returns the
+// entry's index and whether it became the current clipboard; a stale or unfetchable
+// announcement only goes into the history, one with an implausible clock is dropped.
+func (s *Store) StoreFiles(files []models.FileData, ip string, port int, clientID string, remoteClock uint64, unfetchable bool) (int64, bool) {
+	return s.addRemote(Entry{Clock: remoteClock, ClientID: clientID, IP: ip, Port: port, Files: files, Unfetchable: unfetchable})
+}
+
+// StoreLocalContent saves content copied on this machine as the current clipboard and
+// returns the new entry together with the representations to announce: those larger
+// than inlineLimit are replaced by a token to be fetched lazily.
+func (s *Store) StoreLocalContent(reps []models.Representation, inlineLimit int64, ip string, port int, clientID string) (Entry, []models.Representation) {
+	announced := make([]models.Representation, len(reps))
+	for i := range reps {
+		reps[i].Size = int64(len(reps[i].Data))
+		reps[i].Token = newToken()
+
+		announced[i] = reps[i]
+		if reps[i].Size > inlineLimit {
+			announced[i].Data = nil
+		}
+	}
+
+	entry := s.addLocal(Entry{ClientID: clientID, IP: ip, Port: port, Content: reps, Local: true})
+	return entry, announced
+}
+
+// StoreContent saves content announced by a peer, like StoreFiles
+func (s *Store) StoreContent(reps []models.Representation, ip string, port int, clientID string, remoteClock uint64, unfetchable bool) (int64, bool) {
+	return s.addRemote(Entry{Clock: remoteClock, ClientID: clientID, IP: ip, Port: port, Content: reps, Unfetchable: unfetchable})
+}
+
+// addLocal ticks the clock so a local copy is newer than everything seen so far.
+func (s *Store) addLocal(entry Entry) Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock++
+	entry.Clock = s.clock
+	return s.insertLocked(entry)
+}
+
+// addRemote merges the origin's clock into ours and inserts the entry in order.
+func (s *Store) addRemote(entry Entry) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.history {
+		if existing.ClientID == entry.ClientID && existing.Clock == entry.Clock {
+			// Already known, e.g. received again through another peer
+			return existing.Index, false
+		}
+	}
+
+	if entry.Clock > s.clock+maxClockJump {
+		// Merging it would pin every later copy near the top of the clock, or wrap it
+		logger.Warnf("Ignoring clipboard entry from %s: its clock %d is implausibly far ahead of ours (%d)", entry.ClientID, entry.Clock, s.clock)
+		return 0, false
+	}
+	s.clock = max(s.clock, entry.Clock)
+	entry = s.insertLocked(entry)
+	current, _ := s.currentLocked()
+	switch {
+	case entry.Unfetchable:
+		log.Printf("Clipboard entry %d (clock: %d, clientId: %s) cannot be fetched, only keeping it in the history", entry.Index, entry.Clock, entry.ClientID)
+	case current.Index != entry.Index:
+		log.Printf("Clipboard entry %d (clock: %d, clientId: %s) is older than the current clipboard", entry.Index, entry.Clock, entry.ClientID)
+	}
+	return entry.Index, current.Index == entry.Index
+}
+
+func (s *Store) insertLocked(entry Entry) Entry {
+	entry.Index = s.nextIndex
+	entry.Timestamp = time.Now()
+	s.nextIndex++
+
+	pos := len(s.history)
+	for pos > 0 && newer(s.history[pos-1], entry) {
+		pos--
+	}
+	s.history = append(s.history, Entry{})
+	copy(s.history[pos+1:], s.history[pos:])
+	s.history[pos] = entry
+
+	s.pruneLocked()
+	s.saveLocked()
+
+	log.Printf("Saved clipboard entry with index: %d, clock: %d, files: %d, representations: %d, ip: %s, port: %d, clientId: %s",
+		entry.Index, entry.Clock, len(entry.Files), len(entry.Content), entry.IP, entry.Port, entry.ClientID)
+	return entry
+}
+
+// newer reports whether a orders after b; the client ID breaks ties between concurrent copies.
+func newer(a, b Entry) bool {
+	if a.Clock != b.Clock {
+		return a.Clock > b.Clock
+	}
+	return a.ClientID > b.ClientID
+}
+
+// pruneLocked drops entries beyond the configured count and age, always keeping the current one.
+// Unfetchable entries newer than the current one do not push it out.
+func (s *Store) pruneLocked() {
+	current, _ := s.currentLocked()
+	over := 0
+	if s.historyMaxCount > 0 {
+		over = len(s.history) - s.historyMaxCount
+	}
+	cutoff := time.Now().Add(-s.historyMaxAge)
+
+	kept := s.history[:0]
+	for i, entry := range s.history {
+		expired := i < over || (s.historyMaxAge > 0 && entry.Timestamp.Before(cutoff))
+		if expired && entry.Index != current.Index && i < len(s.history)-1 {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	s.history = kept
+}
+
+// Current returns the current clipboard entry
+func (s *Store) Current() (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.currentLocked()
+}
+
+// currentLocked returns the newest entry that can be pasted.
+func (s *Store) currentLocked() (Entry, bool) {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if !s.history[i].Unfetchable {
+			return s.history[i], true
+		}
+	}
+	return Entry{}, false
+}
+
+// Get returns the history entry with the given index
+func (s *Store) Get(index int64) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.history {
+		if entry.Index == index {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// History returns all entries, newest first
+func (s *Store) History() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, len(s.history))
+	for i, entry := range s.history {
+		entries[len(s.history)-1-i] = entry
+	}
+	return entries
+}
+
+// LookupLocalFile returns a file copied on this machine by download token. Tokens stay
+// valid while their entry is in the history, i.e. within HistoryMaxCount and HistoryMaxAge,
+// and survive restarts with it.
+func (s *Store) LookupLocalFile(token string) (models.FileData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.history {
+		if !entry.Local {
+			continue
+		}
+		for _, file := range entry.Files {
+			if file.Token == token {
+				return file, true
+			}
+		}
+	}
+	return models.FileData{}, false
+}
+
+// LookupLocalContent returns the full representation copied on this machine for a token,
+// valid as long as for LookupLocalFile.
+func (s *Store) LookupLocalContent(token string) (models.Representation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.history {
+		if !entry.Local {
+			continue
+		}
+		for _, rep := range entry.Content {
+			if rep.Token == token {
+				return rep, true
+			}
+		}
+	}
+	return models.Representation{}, false
+}
 
-// StoreFiles saves the file list, ip, and port to memory and returns the current index
-func StoreFiles(files []models.FileData, ip string, port int) int64 {
-	storeMu.Lock()
-	defer storeMu.Unlock()
-	currentIndex := nextIndex
-	fileStore = files
-	storedIP = ip
-	storedPort = port
-	fileStoreIndex = currentIndex
-	nextIndex++
-	log.Printf("Saved files with index: %d, ip: %s, port: %d", currentIndex, ip, port)
-	return currentIndex
-}
-
-// GetFiles returns the stored files, ip, and port
-func GetFiles() ([]models.FileData, string, int) {
-	storeMu.Lock()
-	defer storeMu.Unlock()
-	return fileStore, storedIP, storedPort
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/fsutil"
+	"example.com/web-service/internal/logger"
+)
+
+const historyFile = "history.json"
+
+// New applies the history bounds of cfg and restores the clipboard history saved by a previous run.
+func New(cfg *config.Config) *Store {
+	s := &Store{
+		dataDir:         cfg.DataDir,
+		historyMaxCount: cfg.HistoryMaxCount,
+		historyMaxAge:   cfg.HistoryMaxAge.Duration,
+		nextIndex:       1,
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dataDir, historyFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("Failed to read clipboard history: %v", err)
+		}
+		return s
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		logger.Errorf("Failed to parse clipboard history: %v", err)
+		return s
+	}
+
+	s.history = entries
+	sort.SliceStable(s.history, func(i, j int) bool { return newer(s.history[j], s.history[i]) })
+	for _, entry := range s.history {
+		if entry.Index >= s.nextIndex {
+			s.nextIndex = entry.Index + 1
+		}
+		s.clock = max(s.clock, entry.Clock)
+	}
+	s.pruneLocked()
+	log.Printf("Loaded %d clipboard history entries", len(s.history))
+	return s
+}
+
+// saveLocked hands a snapshot of the history to the writer, so marshaling and writing
+// happen outside the lock. Snapshots taken while a write is running replace each other.
+func (s *Store) saveLocked() {
+	s.pending = slices.Clone(s.history)
+	s.dirty = true
+	if !s.saving {
+		s.saving = true
+		go s.writeHistory()
+	}
+}
+
+// writeHistory writes pending snapshots until there are none left. Only one runs at a time.
+func (s *Store) writeHistory() {
+	for {
+		s.mu.Lock()
+		entries, dirty := s.pending, s.dirty
+		s.pending, s.dirty = nil, false
+		if !dirty {
+			s.saving = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		s.write(entries)
+	}
+}
+
+func (s *Store) write(entries []Entry) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		logger.Errorf("Failed to marshal clipboard history: %v", err)
+		return
+	}
+
+	if err := fsutil.WriteFileAtomic(filepath.Join(s.dataDir, historyFile), data, 0600); err != nil {
+		logger.Errorf("Failed to save clipboard history: %v", err)
+	}
+}
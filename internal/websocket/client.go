@@ -1,99 +1,314 @@
 package websocket
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
-	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/retry"
 	"github.com/gorilla/websocket"
 )
 
-const (
-	ReconnectCount = 3
-)
-
 type CloudClient struct {
-	conn      *websocket.Conn
-	hub       *Hub
-	serverURL string
-	send      chan []byte
-	done      chan struct{}
-	onFailure func()
+	conn                 *websocket.Conn
+	hub                  *Hub
+	serverURL            string
+	peerID               string
+	reconnectInterval    time.Duration
+	reconnectMaxInterval time.Duration
+	send                 chan []byte
+	done                 chan struct{} // Closed by Close to stop the client
+	wake                 chan struct{} // Signalled by Wake to retry a parked client
+	closeOnce            sync.Once
+
+	mu             sync.Mutex
+	reconnectCount int  // Failures before parking, 0 retries forever
+	static         bool // Added by address rather than discovered
+	state          PeerState
+	device         pairing.DeviceInfo
+	localIP        string   // Our address on the interface that reaches the peer
+	session        *Session // Of the current connection
+	connectedAt    time.Time
+	seen           seenClock
 }
 
-func NewCloudClient(serverURL string, hub *Hub, onFailure func()) *CloudClient {
+func NewCloudClient(serverURL, peerID string, hub *Hub, reconnectCount int, reconnectInterval, reconnectMaxInterval time.Duration) *CloudClient {
 	return &CloudClient{
-		serverURL: serverURL,
-		hub:       hub,
-		send:      make(chan []byte, 256),
-		done:      make(chan struct{}),
-		onFailure: onFailure,
+		serverURL:            serverURL,
+		peerID:               peerID,
+		hub:                  hub,
+		reconnectCount:       reconnectCount,
+		reconnectInterval:    reconnectInterval,
+		reconnectMaxInterval: reconnectMaxInterval,
+		send:                 make(chan []byte, 256),
+		done:                 make(chan struct{}),
+		wake:                 make(chan struct{}, 1),
+		state:                PeerConnecting,
+		device:               hub.keys.DeviceInfoOf(peerID),
+	}
+}
+
+func (c *CloudClient) info() PeerInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := PeerInfo{
+		ClientID:   c.peerID,
+		DeviceInfo: c.device,
+		Address:    c.serverURL,
+		Direction:  DirectionOutbound,
+		State:      c.state,
+		LastSeen:   c.seen.get(),
+		Static:     c.static,
+	}
+	if c.session != nil {
+		info.Version = c.session.Version()
+	}
+	if c.state == PeerConnected {
+		connectedAt := c.connectedAt
+		info.ConnectedSince = &connectedAt
 	}
+	return info
+}
+
+// makeStatic keeps retrying a discovered client from now on, and at once if it was parked.
+func (c *CloudClient) makeStatic() {
+	c.mu.Lock()
+	c.static = true
+	c.reconnectCount = 0
+	c.mu.Unlock()
+	c.Wake()
 }
 
+// parksAfter reports whether failures failed attempts in a row park the client.
+func (c *CloudClient) parksAfter(failures int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnectCount > 0 && failures >= c.reconnectCount
+}
+
+func (c *CloudClient) setState(state PeerState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+}
+
+// Connect keeps a connection to the peer until Close, reconnecting with backoff.
+// After reconnectCount failed attempts in a row the client is parked until Wake.
 func (c *CloudClient) Connect() {
-	// Reconnection loop
 	go func() {
-		retryCount := 0
+		defer log.Printf("Stopped client for %s", c.serverURL)
+
+		backoff := retry.NewBackoff(c.reconnectInterval, c.reconnectMaxInterval)
+		failures := 0
 		for {
-			u := url.URL{Scheme: "ws", Host: c.serverURL, Path: "/ws"}
+			u := url.URL{Scheme: "wss", Host: c.serverURL, Path: "/ws"}
 			log.Printf("Connecting to cloud: %s", u.String())
 
-			header := http.Header{}
-			header.Add("X-Client-ID", config.ClientID)
-
-			conn, _, err := websocket.DefaultDialer.Dial(u.String(), header)
+			conn, resp, err := c.dial(u)
+			var session *Session
+			if err == nil {
+				session = newSession(conn, c.hub, c.peerID, false, func(message []byte) bool {
+					c.Send(message)
+					return true
+				})
+				if err = session.sendHello(c.hub.selfID); err != nil {
+					conn.Close()
+				}
+			}
 			if err != nil {
-				retryCount++
-				log.Printf("Cloud connection failed (attempt %d/%d): %v. Retrying in 5 seconds...", retryCount, ReconnectCount, err)
-				if retryCount >= ReconnectCount {
-					log.Printf("Max retries reached for %s. Removing client.", c.serverURL)
-					if c.onFailure != nil {
-						c.onFailure()
+				failures++
+				if c.parksAfter(failures) {
+					logger.Warnf("Cloud connection to %s failed %d times: %v. Parked until the peer is discovered again.", c.serverURL, failures, err)
+					if !c.park() {
+						return
 					}
+					log.Printf("Peer at %s seen again, reconnecting", c.serverURL)
+					failures = 0
+					backoff.Reset()
+					continue
+				}
+
+				c.setState(PeerRetrying)
+				delay := backoff.Delay()
+				logger.Warnf("Cloud connection failed (attempt %d): %v. Retrying in %v...", failures, err, delay.Round(time.Millisecond))
+				if !c.wait(delay) {
 					return
 				}
-				time.Sleep(5 * time.Second)
 				continue
 			}
 
 			log.Println("Connected to cloud server")
 			c.conn = conn
-			retryCount = 0
+			failures = 0
+			backoff.Reset()
+
+			c.mu.Lock()
+			c.state = PeerConnected
+			c.connectedAt = time.Now()
+			c.localIP = netutil.HostOf(conn.LocalAddr())
+			c.session = session
+			if device := pairing.DeviceInfoFrom(resp.Header); device.DeviceName != "" {
+				c.device = device
+			}
+			c.mu.Unlock()
+			c.seen.touch()
+			c.hub.BroadcastLocal(peerEvent("peerJoined", c.info()))
 
 			// Handle reading from cloud
-			go c.readPump()
+			readDone := make(chan struct{})
+			go c.readPump(session, readDone)
 			// Handle writing to cloud
-			c.writePump() // This blocks until disconnected
+			c.writePump(session, readDone) // This blocks until disconnected
 
+			c.mu.Lock()
+			c.state = PeerRetrying
+			c.session = nil
+			c.mu.Unlock()
+			c.hub.BroadcastLocal(peerEvent("peerLeft", c.info()))
+			if reason := session.Rejected(); reason != "" {
+				logger.Warnf("Peer at %s is incompatible: %s. Parked until the peer is discovered again.", c.serverURL, reason)
+				if !c.park() {
+					return
+				}
+				continue
+			}
+			// The peer was just there, so the first attempt comes soon
+			if !c.wait(1 * time.Second) {
+				return
+			}
 			log.Println("Disconnected from cloud server. Reconnecting...")
-			time.Sleep(1 * time.Second)
 		}
 	}()
 }
 
-func (c *CloudClient) readPump() {
+// SendPerPeer sends a message built for the local IP that reaches the peer.
+func (c *CloudClient) SendPerPeer(build PeerMessage) {
+	c.mu.Lock()
+	localIP := c.localIP
+	c.mu.Unlock()
+	if localIP == "" {
+		// Not connected yet; ask the routing table
+		if host, _, err := net.SplitHostPort(c.serverURL); err == nil {
+			localIP = netutil.LocalIPFor(host)
+		}
+	}
+	if message := build(localIP); message != nil {
+		c.Send(message)
+	}
+}
+
+// Close stops the client, closing its connection if there is one.
+func (c *CloudClient) Close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// Wake retries a parked client at once. It does nothing to a client that is not parked.
+func (c *CloudClient) Wake() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// park waits for Wake and reports false if the client was closed meanwhile.
+func (c *CloudClient) park() bool {
+	// Drop a wake-up that arrived while we were still trying
+	select {
+	case <-c.wake:
+	default:
+	}
+	c.setState(PeerParked)
+
+	select {
+	case <-c.done:
+		return false
+	case <-c.wake:
+		c.setState(PeerConnecting)
+		return true
+	}
+}
+
+// wait sleeps for d and reports false if the client was closed meanwhile.
+func (c *CloudClient) wait(d time.Duration) bool {
+	select {
+	case <-c.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// dial opens an authenticated connection, accepting only the certificate pinned for the peer.
+func (c *CloudClient) dial(u url.URL) (*websocket.Conn, *http.Response, error) {
+	tlsConfig, err := c.hub.keys.ClientTLSConfig(c.peerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := http.Header{}
+	if err := c.hub.keys.SignRequest(header, c.peerID, "GET", u.RequestURI()); err != nil {
+		return nil, nil, err
+	}
+	c.hub.keys.AddDeviceHeaders(header)
+
+	// Close aborts a dial in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	dialer.EnableCompression = true
+	return dialer.DialContext(ctx, u.String(), header)
+}
+
+func (c *CloudClient) readPump(session *Session, readDone chan struct{}) {
 	defer func() {
 		c.conn.Close()
+		session.close()
+		close(readDone)
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadLimit(c.hub.maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.conn.SetPongHandler(func(string) error {
+		c.seen.touch()
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
 	for {
-		_, message, err := c.conn.ReadMessage()
+		message, err := readMessage(c.conn, c.hub.maxMessageSize)
 		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseProtocolError {
+				// The peer rejected our hello
+				session.setRejected(closeErr.Text)
+			}
 			log.Printf("Cloud read error: %v", err)
 			return
 		}
-		log.Printf("Received from cloud: %s", message)
+		c.seen.touch()
+		logger.Debugf("Received from cloud: %s", message)
 
-		HandleMessage(message)
+		HandleFrame(session, message)
 	}
 }
 
-func (c *CloudClient) writePump() {
+// writePump sends queued messages until the connection fails or readPump stops.
+func (c *CloudClient) writePump(session *Session, readDone chan struct{}) {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
@@ -109,22 +324,15 @@ func (c *CloudClient) writePump() {
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			if err := session.writeQueued(message, c.send); err != nil {
 				return
 			}
+		case <-readDone:
+			return
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+			return
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -138,6 +346,6 @@ func (c *CloudClient) Send(message []byte) {
 	select {
 	case c.send <- message:
 	default:
-		log.Println("Cloud send buffer full, dropping message")
+		logger.Warnf("Cloud send buffer full, dropping message")
 	}
 }
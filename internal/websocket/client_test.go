@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/pairing"
+)
+
+type testPeer struct {
+	hub  *Hub
+	keys *pairing.Keystore
+	addr string
+}
+
+// startPeer serves a running hub over TLS with the pairing endpoints, as an agent does.
+func startPeer(t *testing.T, clientID string) *testPeer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.ClientID = clientID
+	cfg.DeviceName = "device " + clientID
+	cfg.DataDir = t.TempDir()
+	keys := pairing.NewKeystore(cfg)
+	hub := NewHub(cfg, keys, nil)
+	go hub.Run()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pair", func(w http.ResponseWriter, r *http.Request) {
+		var req pairing.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := keys.Respond(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/pair/confirm", func(w http.ResponseWriter, r *http.Request) {
+		var c pairing.Confirmation
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := keys.Confirm(c); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ServeWs(hub, w, r)
+	})
+
+	tlsConfig, err := keys.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return &testPeer{hub: hub, keys: keys, addr: server.Listener.Addr().String()}
+}
+
+// connectPeers pairs b with a and returns b's client for a, connected.
+func connectPeers(t *testing.T, a, b *testPeer) *CloudClient {
+	t.Helper()
+	pin, _ := a.keys.StartPairing()
+	if _, err := b.keys.Join(a.addr, pin); err != nil {
+		t.Fatalf("pairing failed: %v", err)
+	}
+
+	client := NewCloudClient(a.addr, a.keys.ClientID(), b.hub, 0, 10*time.Millisecond, 100*time.Millisecond)
+	t.Cleanup(client.Close)
+	return client
+}
+
+// waitConnected waits until a has registered the inbound connection of clientID.
+func waitConnected(t *testing.T, a *testPeer, clientID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		a.hub.mu.Lock()
+		_, ok := a.hub.clients[clientID]
+		a.hub.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s did not connect", clientID)
+}
+
+// burst returns count test messages, the second of them indented over several lines.
+func burst(t *testing.T, count int) [][]byte {
+	messages := make([][]byte, count)
+	for i := range messages {
+		messages[i] = testMessage(t, i, strings.Repeat("x", 100))
+	}
+	indented, err := json.MarshalIndent(Message{Type: "test", Data: testPayload{N: 1, Text: "line 1\nline 2"}}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages[1] = indented
+	return messages
+}
+
+func TestCloudClientBurstReachesHub(t *testing.T) {
+	for len(received) > 0 {
+		<-received
+	}
+	a := startPeer(t, "a")
+	b := startPeer(t, "b")
+	client := connectPeers(t, a, b)
+
+	// Queued before connecting, the burst goes out batched behind the hello
+	for _, message := range burst(t, 20) {
+		client.Send(message)
+	}
+	client.Connect()
+
+	expect(t, 20)
+}
+
+func TestHubBurstReachesCloudClient(t *testing.T) {
+	for len(received) > 0 {
+		<-received
+	}
+	a := startPeer(t, "a")
+	b := startPeer(t, "b")
+	connectPeers(t, a, b).Connect()
+	waitConnected(t, a, "b")
+
+	for _, message := range burst(t, 20) {
+		a.hub.Broadcast(message)
+	}
+
+	expect(t, 20)
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"example.com/web-service/internal/logger"
+)
+
+const (
+	fragmentType = "fragment"
+
+	// maxFragments bounds a reassembled message to this many times our read limit
+	maxFragments = 64
+)
+
+// FragmentData carries one piece of a message larger than the peer's read limit.
+// The pieces of a message are written back to back and reassembled before dispatch.
+type FragmentData struct {
+	ID    uint64 `json:"id"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+	Data  []byte `json:"data"`
+}
+
+// assembly collects the fragments of one message. Only the read pump touches it.
+type assembly struct {
+	id    uint64
+	count int // As announced by the first fragment
+	parts [][]byte
+	size  int64
+}
+
+// fragment splits message into fragments if it exceeds the peer's limit and the
+// peer can reassemble them. Other messages are returned as they are.
+func (s *Session) fragment(message []byte) [][]byte {
+	s.mu.Lock()
+	limit := s.peerLimit
+	supported := s.capabilities[fragmentType]
+	s.mu.Unlock()
+	if limit <= 0 || int64(len(message)) <= limit || !supported {
+		return [][]byte{message}
+	}
+
+	// Base64 grows each piece by a third, which leaves room for the envelope
+	chunk := int(limit / 2)
+	count := (len(message) + chunk - 1) / chunk
+	if count > maxFragments {
+		// The peer would refuse to reassemble it
+		logger.Errorf("Dropping message of %d bytes to %s: more than %d fragments", len(message), s.ClientID, maxFragments)
+		return nil
+	}
+	id := s.nextFragment.Add(1)
+	frames := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		data, err := json.Marshal(Message{Type: fragmentType, Data: FragmentData{
+			ID:    id,
+			Index: i,
+			Count: count,
+			Data:  message[i*chunk : min((i+1)*chunk, len(message))],
+		}})
+		if err != nil {
+			logger.Errorf("Error marshaling fragment: %v", err)
+			return nil
+		}
+		frames = append(frames, data)
+	}
+	return frames
+}
+
+func handleFragment(s *Session, f FragmentData) error {
+	if f.Index == 0 {
+		s.assembly = &assembly{id: f.ID, count: f.Count}
+	}
+	a := s.assembly
+	if a == nil || a.id != f.ID || f.Index != len(a.parts) || f.Count != a.count || f.Count <= 0 || f.Count > maxFragments {
+		s.assembly = nil
+		return fmt.Errorf("unexpected fragment %d/%d of message %d", f.Index, f.Count, f.ID)
+	}
+
+	a.parts = append(a.parts, f.Data)
+	a.size += int64(len(f.Data))
+	if a.size > maxFragments*s.limit {
+		s.assembly = nil
+		return errors.New("reassembled message too large")
+	}
+	if len(a.parts) < a.count {
+		return nil
+	}
+
+	s.assembly = nil
+	HandleMessage(s, bytes.Join(a.parts, nil))
+	return nil
+}
@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"testing"
+
+	"example.com/web-service/internal/config"
+)
+
+func TestHandleFragment(t *testing.T) {
+	message := testMessage(t, 0, "fragmented")
+	half := len(message) / 2
+
+	tests := []struct {
+		name      string
+		fragments []FragmentData
+		want      bool // Whether the message is dispatched
+	}{
+		{"complete", []FragmentData{
+			{ID: 1, Index: 0, Count: 2, Data: message[:half]},
+			{ID: 1, Index: 1, Count: 2, Data: message[half:]},
+		}, true},
+		{"zero count", []FragmentData{
+			{ID: 1, Index: 0, Count: 0, Data: message},
+		}, false},
+		{"negative count", []FragmentData{
+			{ID: 1, Index: 0, Count: -1, Data: message},
+		}, false},
+		// The last fragment claims a lower count to end the message early
+		{"changed count", []FragmentData{
+			{ID: 1, Index: 0, Count: 3, Data: message[:half]},
+			{ID: 1, Index: 1, Count: 2, Data: message[half:]},
+		}, false},
+		{"too many", []FragmentData{
+			{ID: 1, Index: 0, Count: maxFragments + 1, Data: message},
+		}, false},
+	}
+	for _, tt := range tests {
+		for len(received) > 0 {
+			<-received
+		}
+		hub := NewHub(&config.Config{MaxMessageSize: 1 << 20}, nil, nil)
+		s := newSession(nil, hub, "peer", false, nil)
+		for _, f := range tt.fragments {
+			handleFragment(s, f)
+		}
+		if got := len(received) == 1; got != tt.want {
+			t.Errorf("%s: dispatched %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -5,22 +5,45 @@ import (
 	"sync"
 
 	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/store"
 )
 
 type Hub struct {
-	clients    map[string]*Client // map[ClientID]*Client
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.Mutex
+	selfID         string
+	maxMessageSize int64          // Read limit of every connection
+	allowedOrigins []string       // Web origins that may connect as local clients
+	relayHops      int            // Forwards allowed for announcements we send or relay
+	seen           *seenMessages  // IDs of messages already handled
+	manager        *ClientManager // Set by NewClientManager, for relaying to outbound peers
+	keys           *pairing.Keystore
+	clipboard      *store.Store
+	methods        map[string]Method  // Called by peers, by name
+	clients        map[string]*Client // map[ClientID]*Client
+	broadcast      chan []byte
+	localBroadcast chan []byte // Only delivered to clients on this machine
+	peerBroadcast  chan PeerMessage
+	register       chan *Client
+	unregister     chan *Client
+	mu             sync.Mutex
 }
 
-func NewHub() *Hub {
+func NewHub(cfg *config.Config, keys *pairing.Keystore, clipboard *store.Store) *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[string]*Client),
+		selfID:         cfg.ClientID,
+		keys:           keys,
+		clipboard:      clipboard,
+		methods:        make(map[string]Method),
+		maxMessageSize: max(cfg.MaxMessageSize, minMessageSize),
+		allowedOrigins: cfg.AllowedOrigins,
+		relayHops:      cfg.RelayHops,
+		seen:           newSeenMessages(),
+		broadcast:      make(chan []byte),
+		localBroadcast: make(chan []byte),
+		peerBroadcast:  make(chan PeerMessage),
+		register:       make(chan *Client),
+		unregister:     make(chan *Client),
+		clients:        make(map[string]*Client),
 	}
 }
 
@@ -36,6 +59,9 @@ func (h *Hub) Run() {
 					delete(h.clients, client.ClientID)
 				}
 				h.clients[client.ClientID] = client
+				if !client.local {
+					h.sendLocalLocked(peerEvent("peerJoined", client.info()))
+				}
 			}
 			h.logStats()
 			h.mu.Unlock()
@@ -43,8 +69,7 @@ func (h *Hub) Run() {
 			h.mu.Lock()
 			if client.ClientID != "" {
 				if currentClient, ok := h.clients[client.ClientID]; ok && currentClient == client {
-					delete(h.clients, client.ClientID)
-					close(client.send)
+					h.removeLocked(client)
 				}
 			}
 			h.logStats()
@@ -55,8 +80,30 @@ func (h *Hub) Run() {
 				select {
 				case client.send <- message:
 				default:
-					close(client.send)
-					delete(h.clients, client.ClientID)
+					h.removeLocked(client)
+					h.logStats()
+				}
+			}
+			h.mu.Unlock()
+		case message := <-h.localBroadcast:
+			h.mu.Lock()
+			h.sendLocalLocked(message)
+			h.mu.Unlock()
+		case build := <-h.peerBroadcast:
+			h.mu.Lock()
+			for _, client := range h.clients {
+				localIP := client.localIP
+				if client.local {
+					localIP = ""
+				}
+				message := build(localIP)
+				if message == nil {
+					continue
+				}
+				select {
+				case client.send <- message:
+				default:
+					h.removeLocked(client)
 					h.logStats()
 				}
 			}
@@ -65,14 +112,56 @@ func (h *Hub) Run() {
 	}
 }
 
+func (h *Hub) sendLocalLocked(message []byte) {
+	if message == nil {
+		return
+	}
+	for _, client := range h.clients {
+		if !client.local {
+			continue
+		}
+		select {
+		case client.send <- message:
+		default:
+			close(client.send)
+			delete(h.clients, client.ClientID)
+			h.logStats()
+		}
+	}
+}
+
+// removeLocked closes a registered client and tells local clients when a peer left.
+func (h *Hub) removeLocked(client *Client) {
+	close(client.send)
+	delete(h.clients, client.ClientID)
+	if !client.local {
+		info := client.info()
+		info.State = PeerDisconnected
+		h.sendLocalLocked(peerEvent("peerLeft", info))
+	}
+}
+
 func (h *Hub) logStats() {
 	clientIDs := make([]string, 0, len(h.clients))
-	for id := range h.clients {
+	for id, client := range h.clients {
+		if client.Device.DeviceName != "" {
+			id = client.Device.DeviceName + " (" + id + ")"
+		}
 		clientIDs = append(clientIDs, id)
 	}
-	log.Printf("[Hub] Self ClientID: %s, Total Clients: %d, Connected ClientIDs: %v", config.ClientID, len(h.clients), clientIDs)
+	log.Printf("[Hub] Self ClientID: %s, Total Clients: %d, Connected ClientIDs: %v", h.selfID, len(h.clients), clientIDs)
 }
 
 func (h *Hub) Broadcast(message []byte) {
 	h.broadcast <- message
 }
+
+// BroadcastPerPeer sends a message built for each client from the local IP its connection arrived on.
+func (h *Hub) BroadcastPerPeer(build PeerMessage) {
+	h.peerBroadcast <- build
+}
+
+// BroadcastLocal sends the message to local clients only (e.g. the Mac agent), not to peers.
+func (h *Hub) BroadcastLocal(message []byte) {
+	h.localBroadcast <- message
+}
@@ -8,48 +8,82 @@ import (
 )
 
 type ClientManager struct {
-	clients   map[string]*CloudClient // map[url]*CloudClient
-	clientIDs map[string]string       // map[clientId]url
+	cfg       *config.Config
+	clients   map[string]*CloudClient  // map[url]*CloudClient
+	clientIDs map[string]string        // map[clientId]url
+	static    map[string]staticPeer    // map[clientId]staticPeer
+	resolving map[string]chan struct{} // map[address]stop, configured peers not identified yet
 	hub       *Hub
 	mu        sync.RWMutex
 }
 
-func NewClientManager(hub *Hub) *ClientManager {
-	return &ClientManager{
+func NewClientManager(cfg *config.Config, hub *Hub) *ClientManager {
+	m := &ClientManager{
+		cfg:       cfg,
 		clients:   make(map[string]*CloudClient),
 		clientIDs: make(map[string]string),
+		static:    make(map[string]staticPeer),
+		resolving: make(map[string]chan struct{}),
 		hub:       hub,
 	}
+	hub.manager = m
+	return m
 }
 
+// IsConnected reports whether we connect to the peer. Parked peers do not count,
+// ConnectToCloud wakes them.
 func (m *ClientManager) IsConnected(clientId string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	_, exists := m.clientIDs[clientId]
-	return exists
+	url, exists := m.clientIDs[clientId]
+	if !exists {
+		return false
+	}
+	client := m.clients[url]
+	return client == nil || client.info().State != PeerParked
 }
 
 func (m *ClientManager) ConnectToCloud(url string, clientId string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.clients[url]; exists {
-		// Already connected or connecting to this URL
+	static := m.isStatic(clientId)
+	if client, exists := m.clients[url]; exists {
+		// Already connected or connecting to this URL; retry it now if it was parked
+		if static {
+			client.makeStatic()
+		}
+		client.Wake()
 		return
 	}
 
 	if clientId != "" {
-		if _, exists := m.clientIDs[clientId]; exists {
-			// Already connected to this ClientID (maybe different URL?)
-			return
+		if existing, exists := m.clientIDs[clientId]; exists {
+			client := m.clients[existing]
+			if client == nil || client.info().State != PeerParked {
+				// Already connected to this ClientID (maybe different URL?)
+				if client != nil && static {
+					client.makeStatic()
+				}
+				return
+			}
+			// The parked peer showed up at another address, which replaces the old one
+			log.Printf("Peer %s moved from %s to %s", clientId, existing, url)
+			client.Close()
+			delete(m.clients, existing)
 		}
 		m.clientIDs[clientId] = url
 	}
 
+	// Static peers were added on purpose, keep trying them
+	reconnectCount := m.cfg.ReconnectCount
+	if static {
+		reconnectCount = 0
+	}
+
 	log.Printf("Initiating connection to new cloud server: %s (ClientID: %s)", url, clientId)
-	client := NewCloudClient(url, m.hub, func() {
-		m.RemoveClient(url)
-	})
+	client := NewCloudClient(url, clientId, m.hub, reconnectCount, m.cfg.ReconnectInterval.Duration, m.cfg.ReconnectMaxInterval.Duration)
+	client.static = static
 	m.clients[url] = client
 	client.Connect()
 	m.logStats()
@@ -73,6 +107,24 @@ func (m *ClientManager) RemoveClient(url string) {
 	m.logStats()
 }
 
+// Expire stops connecting to a peer that no longer announces itself. A working
+// connection is left open, its pings show the peer is still there. Static peers
+// do not need announcements and are never expired.
+func (m *ClientManager) Expire(clientId string) {
+	m.mu.RLock()
+	url, ok := m.clientIDs[clientId]
+	client := m.clients[url]
+	static := m.isStatic(clientId)
+	m.mu.RUnlock()
+	if !ok || static || client == nil || client.info().State == PeerConnected {
+		return
+	}
+
+	log.Printf("Peer %s stopped announcing itself, no longer connecting to %s", clientId, url)
+	client.Close()
+	m.RemoveClient(url)
+}
+
 func (m *ClientManager) Broadcast(message []byte) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -82,10 +134,20 @@ func (m *ClientManager) Broadcast(message []byte) {
 	}
 }
 
+// BroadcastPerPeer sends each peer a message built for the local IP that reaches it.
+func (m *ClientManager) BroadcastPerPeer(build PeerMessage) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, client := range m.clients {
+		client.SendPerPeer(build)
+	}
+}
+
 func (m *ClientManager) logStats() {
 	connectedIDs := make([]string, 0, len(m.clientIDs))
 	for id := range m.clientIDs {
 		connectedIDs = append(connectedIDs, id)
 	}
-	log.Printf("[ClientManager] Self ClientID: %s, Total Connections: %d, Connected Cloud ClientIDs: %v", config.ClientID, len(m.clients), connectedIDs)
+	log.Printf("[ClientManager] Self ClientID: %s, Total Connections: %d, Connected Cloud ClientIDs: %v", m.cfg.ClientID, len(m.clients), connectedIDs)
 }
@@ -1,35 +1,133 @@
 package websocket
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
+	"sort"
 
+	"example.com/web-service/internal/fsutil"
+	"example.com/web-service/internal/logger"
 	"example.com/web-service/internal/models"
-	"example.com/web-service/internal/store"
 )
 
 // Message represents a generic WebSocket message
 type Message struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data,omitempty"`
+	Relay
 }
 
-// HandleMessage parses and processes incoming WebSocket messages
-func HandleMessage(message []byte) {
-	var msg Message
-	if err := json.Unmarshal(message, &msg); err == nil {
-		log.Printf("Parsed Message - Type: %s, Data: %+v", msg.Type, msg.Data)
-		if msg.Type == "copyFileInfoToCloud" {
-			if dataBytes, err := json.Marshal(msg.Data); err == nil {
-				var payload models.CopyFileInfoData
-				if err := json.Unmarshal(dataBytes, &payload); err == nil {
-					store.StoreFiles(payload.Files, payload.IP, payload.Port)
-				} else {
-					log.Printf("Failed to parse copyFileInfoToCloud data: %v", err)
-				}
+// rawMessage is a received Message, its payload left for the handler of its type.
+type rawMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+	Relay
+}
+
+// Handler processes the payload of one message type received on a session.
+type Handler func(s *Session, data json.RawMessage) error
+
+// handlers by message type. The types are announced as capabilities in hello.
+var handlers = make(map[string]Handler)
+
+func init() {
+	RegisterHandler(helloType, Typed(handleHello))
+	RegisterHandler(fragmentType, Typed(handleFragment))
+	RegisterHandler(requestType, Typed(handleRequest))
+	RegisterHandler(responseType, Typed(handleResponse))
+	RegisterHandler("copyFileInfoToCloud", Typed(func(s *Session, payload models.CopyFileInfoData) error {
+		for _, file := range payload.Files {
+			// A name like "../x" would be pasted outside the destination
+			if !fsutil.IsPlainName(file.Name) {
+				return fmt.Errorf("invalid file name %q", file.Name)
 			}
 		}
-	} else {
-		log.Printf("Failed to parse message as generic Message: %v", err)
+		s.hub.clipboard.StoreFiles(payload.Files, payload.IP, payload.Port, payload.ClientID, payload.Clock, !s.hub.keys.IsPaired(payload.ClientID))
+		return nil
+	}))
+	RegisterHandler("copyContent", Typed(func(s *Session, payload models.CopyContentData) error {
+		unfetchable := !s.hub.keys.IsPaired(payload.ClientID) && !inline(payload.Representations)
+		s.hub.clipboard.StoreContent(payload.Representations, payload.IP, payload.Port, payload.ClientID, payload.Clock, unfetchable)
+		return nil
+	}))
+}
+
+// inline reports whether all representations arrived with their data, so none has to
+// be fetched from the origin. A relayed announcement may come from an origin we are
+// not paired with.
+func inline(reps []models.Representation) bool {
+	for _, rep := range reps {
+		if rep.Data == nil && rep.Size > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RegisterHandler sets the handler of a message type. It must be called before connections are made.
+func RegisterHandler(msgType string, handler Handler) {
+	handlers[msgType] = handler
+}
+
+// Typed adapts a handler of a decoded payload.
+func Typed[T any](handle func(s *Session, payload T) error) Handler {
+	return func(s *Session, data json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return handle(s, payload)
+	}
+}
+
+// Capabilities lists the message types we handle, besides hello.
+func Capabilities() []string {
+	list := make([]string, 0, len(handlers))
+	for msgType := range handlers {
+		if msgType != helloType {
+			list = append(list, msgType)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// HandleFrame dispatches every message in a frame. Frames carry newline-delimited
+// JSON: writers batch queued messages into one frame, and JSON has no raw newlines.
+func HandleFrame(s *Session, frame []byte) {
+	for _, message := range bytes.Split(frame, []byte{'\n'}) {
+		if len(bytes.TrimSpace(message)) > 0 {
+			HandleMessage(s, message)
+		}
+	}
+}
+
+// HandleMessage parses and processes incoming WebSocket messages
+func HandleMessage(s *Session, message []byte) {
+	var msg rawMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		logger.Warnf("Failed to parse message as generic Message: %v", err)
+		return
+	}
+	logger.Debugf("Parsed Message - Type: %s, Data: %s", msg.Type, msg.Data)
+	if msg.ID != "" && !s.hub.seen.add(msg.ID) {
+		// Already heard through another peer, or our own coming back
+		logger.Debugf("Dropping duplicate message %s from %s", msg.ID, s.ClientID)
+		return
+	}
+
+	handler, ok := handlers[msg.Type]
+	if !ok {
+		// Newer peers may send types we do not know yet
+		logger.Debugf("Ignoring message of unknown type %q from %s", msg.Type, s.ClientID)
+		return
+	}
+	if err := handler(s, msg.Data); err != nil {
+		logger.Errorf("Failed to handle %s message from %s: %v", msg.Type, s.ClientID, err)
+		return
+	}
+	if relayed[msg.Type] {
+		s.hub.relay(s, msg)
 	}
 }
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/models"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/store"
+)
+
+func TestCopyFileInfoRejectsEscapingNames(t *testing.T) {
+	cfg := config.Default()
+	cfg.ClientID = "self"
+	// The history is written in the background, which t.TempDir's cleanup could trip over
+	dataDir, err := os.MkdirTemp("", "pasteflow-self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+	cfg.DataDir = dataDir
+	keys := pairing.NewKeystore(cfg)
+	clipboard := store.New(cfg)
+	hub := NewHub(cfg, keys, clipboard)
+	s := newSession(nil, hub, "peer", false, func([]byte) bool { return true })
+
+	announce := func(name string) {
+		data, err := json.Marshal(Message{Type: "copyFileInfoToCloud", Data: models.CopyFileInfoData{
+			Files:    []models.FileData{{Name: name, Token: "t"}},
+			ClientID: "peer",
+			Clock:    uint64(len(clipboard.History()) + 1),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		HandleMessage(s, data)
+	}
+
+	announce("../../.bashrc")
+	if n := len(clipboard.History()); n != 0 {
+		t.Fatalf("stored an announcement of ../../.bashrc: %d entries", n)
+	}
+	announce("notes.txt")
+	if n := len(clipboard.History()); n != 1 {
+		t.Fatalf("got %d entries after a valid announcement, want 1", n)
+	}
+}
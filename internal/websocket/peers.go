@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
+)
+
+type PeerState string
+
+const (
+	PeerConnecting PeerState = "connecting"
+	PeerConnected  PeerState = "connected"
+	PeerRetrying   PeerState = "retrying"
+	// PeerParked peers failed too often; they are retried when discovery sees them again
+	PeerParked PeerState = "parked"
+	// PeerDisconnected appears in peerLeft messages of inbound peers and for static peers without a client
+	PeerDisconnected PeerState = "disconnected"
+)
+
+const (
+	DirectionInbound  = "inbound"  // The peer connected to our /ws endpoint
+	DirectionOutbound = "outbound" // We connected to the peer as a CloudClient
+)
+
+// PeerInfo describes one peer connection for GET /api/peers and the peerJoined/peerLeft messages.
+type PeerInfo struct {
+	ClientID string `json:"clientId"`
+	pairing.DeviceInfo
+	Address        string     `json:"address"`
+	Direction      string     `json:"direction"`
+	State          PeerState  `json:"state"`
+	ConnectedSince *time.Time `json:"connectedSince,omitempty"`
+	LastSeen       *time.Time `json:"lastSeen,omitempty"`
+	Static         bool       `json:"static,omitempty"`  // Added by address, kept connected
+	Version        int        `json:"version,omitempty"` // Negotiated protocol version
+}
+
+// PeerMessage builds a message for one peer. localIP is the address of our interface
+// that reaches the peer, or empty for clients on this machine.
+type PeerMessage func(localIP string) []byte
+
+// seenClock records when a connection last received anything, including pongs.
+type seenClock struct {
+	unixNano atomic.Int64
+}
+
+func (s *seenClock) touch() {
+	s.unixNano.Store(time.Now().UnixNano())
+}
+
+func (s *seenClock) get() *time.Time {
+	n := s.unixNano.Load()
+	if n == 0 {
+		return nil
+	}
+	t := time.Unix(0, n)
+	return &t
+}
+
+// Peers lists the inbound peer connections. Local clients such as the Mac agent are not peers.
+func (h *Hub) Peers() []PeerInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	list := make([]PeerInfo, 0, len(h.clients))
+	for _, client := range h.clients {
+		if !client.local {
+			list = append(list, client.info())
+		}
+	}
+	return list
+}
+
+// Peers lists inbound and outbound peer connections.
+func (m *ClientManager) Peers() []PeerInfo {
+	list := m.hub.Peers()
+
+	m.mu.RLock()
+	for _, client := range m.clients {
+		list = append(list, client.info())
+	}
+	list = append(list, m.unconnectedStaticLocked()...)
+	m.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ClientID != list[j].ClientID {
+			return list[i].ClientID < list[j].ClientID
+		}
+		return list[i].Direction < list[j].Direction
+	})
+	return list
+}
+
+// peerEvent builds a peerJoined or peerLeft message for local clients.
+func peerEvent(eventType string, peer PeerInfo) []byte {
+	data, err := json.Marshal(Message{Type: eventType, Data: peer})
+	if err != nil {
+		logger.Errorf("Error marshaling %s message: %v", eventType, err)
+		return nil
+	}
+	return data
+}
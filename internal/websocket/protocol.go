@@ -0,0 +1,231 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
+	"github.com/gorilla/websocket"
+)
+
+// Peers open a connection by sending each other a hello with their protocol version
+// and capabilities, then speak the highest version both support. A peer that never
+// says hello speaks version 1, the protocol from before the handshake.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+	legacyVersion      = 1
+
+	helloType = "hello"
+)
+
+// HelloData is the payload of hello.
+type HelloData struct {
+	Version    int    `json:"version"`
+	MinVersion int    `json:"minVersion"` // Oldest version the sender still speaks
+	ClientID   string `json:"clientId"`
+	pairing.DeviceInfo
+	Capabilities   []string `json:"capabilities,omitempty"`   // Message types the sender handles
+	MaxMessageSize int64    `json:"maxMessageSize,omitempty"` // Read limit of the sender
+}
+
+// Session is the protocol state of one connection.
+type Session struct {
+	ClientID string // Verified identity of the peer; for local clients what they claim
+	Local    bool   // A client on this machine, which needs no hello
+
+	conn         *websocket.Conn
+	hub          *Hub
+	send         func(message []byte) bool // Queues a message for the write pump
+	closed       chan struct{}             // Closed with the connection
+	closeOnce    sync.Once
+	limit        int64 // Our read limit
+	nextFragment atomic.Uint64
+	nextCall     atomic.Uint64
+	requests     chan struct{} // Semaphore of the peer's requests being answered
+	assembly     *assembly
+
+	mu           sync.Mutex
+	version      int
+	capabilities map[string]bool // nil until hello: a legacy peer
+	peerLimit    int64           // Read limit of the peer, 0 if unknown
+	rejected     string          // Why the versions are incompatible
+	calls        map[uint64]chan ResponseData
+}
+
+func newSession(conn *websocket.Conn, hub *Hub, clientID string, local bool, send func([]byte) bool) *Session {
+	s := &Session{
+		ClientID: clientID,
+		Local:    local,
+		conn:     conn,
+		hub:      hub,
+		send:     send,
+		closed:   make(chan struct{}),
+		limit:    hub.maxMessageSize,
+		version:  legacyVersion,
+		calls:    make(map[uint64]chan ResponseData),
+		requests: make(chan struct{}, maxInFlightRequests),
+	}
+	if !local {
+		// Until hello says otherwise the peer may be an old build
+		s.peerLimit = legacyMessageSize
+	}
+	return s
+}
+
+// close ends pending calls; the read pump calls it when the connection is gone.
+func (s *Session) close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// Version is the negotiated protocol version.
+func (s *Session) Version() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// Supports reports whether the peer handles a message type. Legacy peers handle
+// the types of version 1 only.
+func (s *Session) Supports(msgType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.capabilities == nil {
+		return msgType == "copyFileInfoToCloud" || msgType == "copyContent"
+	}
+	return s.capabilities[msgType]
+}
+
+// Rejected returns why the connection was closed for incompatible versions, or "".
+func (s *Session) Rejected() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rejected
+}
+
+func (s *Session) setRejected(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected = reason
+}
+
+// sendHello writes our hello. It must be the first message, before the write pump starts.
+func (s *Session) sendHello(selfID string) error {
+	data, err := json.Marshal(Message{Type: helloType, Data: HelloData{
+		Version:        ProtocolVersion,
+		MinVersion:     MinProtocolVersion,
+		ClientID:       selfID,
+		DeviceInfo:     s.hub.keys.Self(),
+		Capabilities:   Capabilities(),
+		MaxMessageSize: s.limit,
+	}})
+	if err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func handleHello(s *Session, hello HelloData) error {
+	if hello.Version < MinProtocolVersion || hello.MinVersion > ProtocolVersion {
+		reason := fmt.Sprintf("protocol version %d-%d is incompatible with %d-%d", hello.MinVersion, hello.Version, MinProtocolVersion, ProtocolVersion)
+		logger.Warnf("Closing connection to %s: %s", s.ClientID, reason)
+		s.setRejected(reason)
+		// WriteControl is safe alongside the write pump; the peer's close reply ends the read pump
+		s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, reason), time.Now().Add(writeWait))
+		return nil
+	}
+	if !s.Local && hello.ClientID != s.ClientID {
+		logger.Warnf("Peer %s said hello as %s", s.ClientID, hello.ClientID)
+	}
+
+	capabilities := make(map[string]bool, len(hello.Capabilities))
+	for _, c := range hello.Capabilities {
+		capabilities[c] = true
+	}
+	s.mu.Lock()
+	s.version = min(hello.Version, ProtocolVersion)
+	s.capabilities = capabilities
+	if hello.MaxMessageSize > 0 {
+		s.peerLimit = hello.MaxMessageSize
+	}
+	version := s.version
+	s.mu.Unlock()
+
+	if !s.Local && hello.DeviceName != "" {
+		s.hub.keys.NoteDeviceInfo(s.ClientID, hello.DeviceInfo)
+	}
+	log.Printf("Hello from %s (%s): protocol version %d, speaking %d, capabilities %v", s.ClientID, hello.DeviceName, hello.Version, version, hello.Capabilities)
+	return nil
+}
+
+// writeQueued writes message and the messages queued behind it. They are batched as
+// newline-delimited JSON into frames within the peer's limit; larger ones are fragmented.
+func (s *Session) writeQueued(message []byte, queue chan []byte) error {
+	messages := [][]byte{oneLine(message)}
+	for n := len(queue); n > 0; n-- {
+		messages = append(messages, oneLine(<-queue))
+	}
+	s.mu.Lock()
+	limit := s.peerLimit
+	s.mu.Unlock()
+
+	var frame []byte
+	for _, m := range messages {
+		for _, part := range s.fragment(m) {
+			if len(frame) > 0 && limit > 0 && int64(len(frame)+1+len(part)) > limit {
+				if err := s.writeFrame(frame); err != nil {
+					return err
+				}
+				frame = nil
+			}
+			if len(frame) > 0 {
+				frame = append(frame, '\n')
+			}
+			frame = append(frame, part...)
+		}
+	}
+	return s.writeFrame(frame)
+}
+
+// readMessage reads the next message from conn. Unlike conn.ReadMessage it also bounds
+// the message after decompression, since the read limit only counts the bytes on the wire.
+func readMessage(conn *websocket.Conn, limit int64) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	message, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(message)) > limit {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(writeWait))
+		return nil, websocket.ErrReadLimit
+	}
+	return message, nil
+}
+
+// oneLine compacts a message that contains newlines, which would split it on the wire.
+func oneLine(message []byte) []byte {
+	if bytes.IndexByte(message, '\n') < 0 {
+		return message
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, message); err != nil {
+		return bytes.ReplaceAll(message, []byte{'\n'}, []byte{' '})
+	}
+	return buf.Bytes()
+}
+
+func (s *Session) writeFrame(frame []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return s.conn.WriteMessage(websocket.TextMessage, frame)
+}
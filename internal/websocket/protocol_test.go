@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/web-service/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+type testPayload struct {
+	N    int    `json:"n"`
+	Text string `json:"text"`
+}
+
+// received collects the payloads of test messages in the order they were dispatched.
+var received = make(chan testPayload, 100)
+
+func init() {
+	RegisterHandler("test", Typed(func(s *Session, payload testPayload) error {
+		received <- payload
+		return nil
+	}))
+}
+
+// connect opens a connection to a server that dispatches every frame it reads, and returns
+// the writing session and the sizes of the frames read. A local session has no size limit;
+// any other starts with the limit of legacy peers.
+func connect(t *testing.T, local bool) (*Session, chan int) {
+	t.Helper()
+	for len(received) > 0 {
+		<-received
+	}
+
+	hub := NewHub(&config.Config{MaxMessageSize: 1 << 20}, nil, nil)
+	frames := make(chan int, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		s := newSession(conn, hub, "writer", true, nil)
+		for {
+			frame, err := readMessage(conn, hub.maxMessageSize)
+			if err != nil {
+				return
+			}
+			frames <- len(frame)
+			HandleFrame(s, frame)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return newSession(conn, hub, "reader", local, nil), frames
+}
+
+func testMessage(t *testing.T, n int, text string) []byte {
+	t.Helper()
+	data, err := json.Marshal(Message{Type: "test", Data: testPayload{N: n, Text: text}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// expect waits for the test messages 0 to count-1, in order.
+func expect(t *testing.T, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		select {
+		case payload := <-received:
+			if payload.N != i {
+				t.Fatalf("got message %d, want %d", payload.N, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d messages", i, count)
+		}
+	}
+}
+
+func TestWriteQueuedBatchesIntoOneFrame(t *testing.T) {
+	s, frames := connect(t, true)
+
+	queue := make(chan []byte, 10)
+	for i := 1; i < 10; i++ {
+		queue <- testMessage(t, i, "queued")
+	}
+	if err := s.writeQueued(testMessage(t, 0, "first"), queue); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, 10)
+	if n := len(frames); n != 1 {
+		t.Errorf("messages were written in %d frames, want 1", n)
+	}
+}
+
+func TestWriteQueuedSplitsFramesAtPeerLimit(t *testing.T) {
+	s, frames := connect(t, false)
+
+	queue := make(chan []byte, 20)
+	for i := 1; i < 20; i++ {
+		queue <- testMessage(t, i, strings.Repeat("x", 100))
+	}
+	if err := s.writeQueued(testMessage(t, 0, strings.Repeat("x", 100)), queue); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, 20)
+	if len(frames) < 2 {
+		t.Errorf("messages were written in %d frames, want several", len(frames))
+	}
+	for len(frames) > 0 {
+		if size := <-frames; size > legacyMessageSize {
+			t.Errorf("frame of %d bytes exceeds the peer's limit of %d", size, legacyMessageSize)
+		}
+	}
+}
+
+func TestWriteQueuedCompactsMultilineMessages(t *testing.T) {
+	s, _ := connect(t, true)
+
+	indented, err := json.MarshalIndent(Message{Type: "test", Data: testPayload{N: 1, Text: "line 1\nline 2"}}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := make(chan []byte, 2)
+	queue <- indented
+	queue <- testMessage(t, 2, "after")
+	if err := s.writeQueued(testMessage(t, 0, "before"), queue); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, 3)
+}
+
+func TestOneLine(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{"type":"test"}`, `{"type":"test"}`},
+		{"{\n  \"type\": \"test\",\n  \"data\": \"a\\nb\"\n}", `{"type":"test","data":"a\nb"}`},
+		{"not\njson", "not json"},
+	}
+	for _, tt := range tests {
+		got := string(oneLine([]byte(tt.in)))
+		if got != tt.want {
+			t.Errorf("oneLine(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if strings.Contains(got, "\n") {
+			t.Errorf("oneLine(%q) kept a newline", tt.in)
+		}
+	}
+}
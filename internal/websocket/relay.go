@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"example.com/web-service/internal/logger"
+)
+
+// Clipboard announcements are relayed between peers so they reach devices that only
+// some peers can connect to. Every announcement carries an ID, its origin and the
+// number of forwards left; each peer handles an ID once and forwards it to the others.
+// Relayed announcements keep the origin's address, which the receiver may not reach.
+// If the receiver is not paired with the origin it cannot download from it either; such
+// announcements only become the current clipboard when all their content is inline.
+
+const (
+	// seenTTL is how long a message ID is remembered, far longer than a relay takes
+	seenTTL = 10 * time.Minute
+	// maxSeen is the cache size at which expired IDs are pruned
+	maxSeen = 4096
+)
+
+// relayed lists the message types forwarded between peers.
+var relayed = map[string]bool{
+	"copyFileInfoToCloud": true,
+	"copyContent":         true,
+}
+
+// Relay identifies a message for relaying. It is empty for messages that are not relayed.
+type Relay struct {
+	ID     string `json:"id,omitempty"`
+	Origin string `json:"origin,omitempty"` // Client ID of the device that sent it first
+	Hops   int    `json:"hops,omitempty"`   // Forwards left
+}
+
+// NewRelay stamps an announcement we originate. Use the same stamp for every peer.
+func (h *Hub) NewRelay() Relay {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	h.seen.add(id)
+	return Relay{ID: id, Origin: h.selfID, Hops: h.relayHops}
+}
+
+// relay forwards a message to every connected peer except the one it came from and its origin.
+func (h *Hub) relay(from *Session, msg rawMessage) {
+	hops := min(msg.Hops, h.relayHops) - 1
+	if hops < 0 || msg.ID == "" {
+		return
+	}
+	msg.Hops = hops
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	sent := map[string]bool{from.ClientID: true, msg.Origin: true, h.selfID: true}
+	count := 0
+	h.mu.Lock()
+	for id, client := range h.clients {
+		if client.local || sent[id] {
+			continue
+		}
+		select {
+		case client.send <- data:
+			sent[id] = true
+			count++
+		default:
+		}
+	}
+	h.mu.Unlock()
+
+	if h.manager != nil {
+		h.manager.mu.RLock()
+		for _, client := range h.manager.clients {
+			if sent[client.peerID] || client.info().State != PeerConnected {
+				continue
+			}
+			client.Send(data)
+			sent[client.peerID] = true
+			count++
+		}
+		h.manager.mu.RUnlock()
+	}
+	logger.Debugf("Relayed %s %s from %s to %d peers, %d hops left", msg.Type, msg.ID, from.ClientID, count, hops)
+}
+
+// seenMessages remembers the IDs of handled messages to drop duplicates and loops.
+type seenMessages struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+func newSeenMessages() *seenMessages {
+	return &seenMessages{ids: make(map[string]time.Time)}
+}
+
+// add records an ID and reports whether it is new.
+func (s *seenMessages) add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if at, ok := s.ids[id]; ok && now.Sub(at) < seenTTL {
+		return false
+	}
+	if len(s.ids) >= maxSeen {
+		for old, at := range s.ids {
+			if now.Sub(at) >= seenTTL {
+				delete(s.ids, old)
+			}
+		}
+	}
+	s.ids[id] = now
+	return true
+}
@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"example.com/web-service/internal/logger"
+)
+
+// Requests and responses carry a correlation ID chosen by the caller. Either side of
+// a connection may call the other.
+const (
+	requestType  = "request"
+	responseType = "response"
+
+	// defaultCallTimeout applies to calls whose context has no deadline
+	defaultCallTimeout = 10 * time.Second
+	// maxInFlightRequests bounds the requests of one peer being answered at a time
+	maxInFlightRequests = 16
+)
+
+var (
+	ErrPeerNotConnected = errors.New("peer not connected")
+	ErrUnsupported      = errors.New("peer does not support requests")
+)
+
+type RequestData struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type ResponseData struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// RemoteError is returned by Call when the peer's method failed.
+type RemoteError struct {
+	Method  string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("%s failed on peer: %s", e.Method, e.Message)
+}
+
+// Method answers a request from a peer. The result is sent back as JSON.
+type Method func(s *Session, params json.RawMessage) (interface{}, error)
+
+// RegisterMethod sets the method peers can call by name. It must be called before connections are made.
+func (h *Hub) RegisterMethod(name string, method Method) {
+	h.methods[name] = method
+}
+
+// Call sends a request to the peer and waits for its result, the context's
+// deadline or the end of the connection.
+func (s *Session) Call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	if !s.Supports(requestType) {
+		return nil, ErrUnsupported
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCallTimeout)
+		defer cancel()
+	}
+
+	request := RequestData{ID: s.nextCall.Add(1), Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		request.Params = raw
+	}
+	data, err := json.Marshal(Message{Type: requestType, Data: request})
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make(chan ResponseData, 1)
+	s.mu.Lock()
+	s.calls[request.ID] = reply
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.calls, request.ID)
+		s.mu.Unlock()
+	}()
+
+	if !s.send(data) {
+		return nil, ErrPeerNotConnected
+	}
+	select {
+	case response := <-reply:
+		if response.Error != "" {
+			return nil, &RemoteError{Method: method, Message: response.Error}
+		}
+		return response.Result, nil
+	case <-s.closed:
+		return nil, ErrPeerNotConnected
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func handleRequest(s *Session, request RequestData) error {
+	select {
+	case s.requests <- struct{}{}:
+	default:
+		reply(s, request, ResponseData{ID: request.ID, Error: "too many requests"})
+		return fmt.Errorf("%d requests in flight, refused %s", maxInFlightRequests, request.Method)
+	}
+
+	// Methods may take a while; keep reading meanwhile
+	go func() {
+		defer func() { <-s.requests }()
+		response := ResponseData{ID: request.ID}
+		if method, ok := s.hub.methods[request.Method]; !ok {
+			response.Error = "unknown method " + request.Method
+		} else if result, err := method(s, request.Params); err != nil {
+			response.Error = err.Error()
+		} else if response.Result, err = json.Marshal(result); err != nil {
+			response.Error = err.Error()
+		}
+		reply(s, request, response)
+	}()
+	return nil
+}
+
+func reply(s *Session, request RequestData, response ResponseData) {
+	data, err := json.Marshal(Message{Type: responseType, Data: response})
+	if err != nil {
+		logger.Errorf("Error marshaling response to %s: %v", request.Method, err)
+		return
+	}
+	s.send(data)
+}
+
+func handleResponse(s *Session, response ResponseData) error {
+	s.mu.Lock()
+	reply, ok := s.calls[response.ID]
+	s.mu.Unlock()
+	if !ok {
+		// The call timed out already
+		return fmt.Errorf("response to unknown request %d", response.ID)
+	}
+	select {
+	case reply <- response:
+	default:
+		// A duplicate; the caller has its answer
+	}
+	return nil
+}
+
+// Call sends a request to a peer connected to us and waits for its result.
+func (h *Hub) Call(ctx context.Context, peerID, method string, params interface{}) (json.RawMessage, error) {
+	s := h.session(peerID)
+	if s == nil {
+		return nil, ErrPeerNotConnected
+	}
+	return s.Call(ctx, method, params)
+}
+
+// Call sends a request to a peer, over our connection to it or its connection to us,
+// and waits for its result.
+func (m *ClientManager) Call(ctx context.Context, peerID, method string, params interface{}) (json.RawMessage, error) {
+	s := m.session(peerID)
+	if s == nil {
+		s = m.hub.session(peerID)
+	}
+	if s == nil {
+		return nil, ErrPeerNotConnected
+	}
+	return s.Call(ctx, method, params)
+}
+
+// session returns the session of a connected peer that connected to us.
+func (h *Hub) session(peerID string) *Session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client, ok := h.clients[peerID]
+	if !ok || client.local {
+		return nil
+	}
+	return client.session
+}
+
+// session returns the session of our connection to a peer, if it is up.
+func (m *ClientManager) session(peerID string) *Session {
+	m.mu.RLock()
+	client := m.clients[m.clientIDs[peerID]]
+	m.mu.RUnlock()
+	if client == nil {
+		return nil
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.session
+}
+
+// sendTo queues a message for one client unless it was removed meanwhile.
+func (h *Hub) sendTo(client *Client, message []byte) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[client.ClientID] != client {
+		return false
+	}
+	select {
+	case client.send <- message:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"example.com/web-service/internal/config"
+)
+
+func TestHandleRequestLimitsInFlight(t *testing.T) {
+	hub := NewHub(&config.Config{}, nil, nil)
+	release := make(chan struct{})
+	hub.RegisterMethod("wait", func(s *Session, params json.RawMessage) (interface{}, error) {
+		<-release
+		return "done", nil
+	})
+
+	responses := make(chan ResponseData, maxInFlightRequests+1)
+	s := newSession(nil, hub, "peer", false, func(message []byte) bool {
+		var msg struct {
+			Data ResponseData `json:"data"`
+		}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			t.Error(err)
+		}
+		responses <- msg.Data
+		return true
+	})
+
+	for id := uint64(1); id <= maxInFlightRequests+1; id++ {
+		data, err := json.Marshal(Message{Type: requestType, Data: RequestData{ID: id, Method: "wait"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		HandleMessage(s, data)
+	}
+
+	select {
+	case response := <-responses:
+		if response.ID != maxInFlightRequests+1 || response.Error == "" {
+			t.Fatalf("got %+v, want request %d refused", response, maxInFlightRequests+1)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request over the limit was not refused")
+	}
+
+	close(release)
+	for i := 0; i < maxInFlightRequests; i++ {
+		select {
+		case response := <-responses:
+			if response.Error != "" {
+				t.Errorf("request %d failed: %s", response.ID, response.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d responses", i, maxInFlightRequests)
+		}
+	}
+}
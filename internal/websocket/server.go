@@ -3,21 +3,29 @@ package websocket
 import (
 	"log"
 	"net/http"
+	"slices"
 	"time"
 
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/netutil"
+	"example.com/web-service/internal/pairing"
 	"github.com/gorilla/websocket"
 )
 
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
+	// legacyMessageSize is the read limit of peers from before the hello handshake
+	legacyMessageSize = 512
+	// minMessageSize keeps a configured read limit large enough to fragment into
+	minMessageSize = 4096
 )
 
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	EnableCompression: true,
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
@@ -28,27 +36,55 @@ type Client struct {
 	conn     *websocket.Conn
 	send     chan []byte
 	ClientID string
+	Device   pairing.DeviceInfo
+	local    bool // Connected from this machine
+
+	session     *Session
+	address     string
+	localIP     string // Our address on the interface the peer connected to
+	connectedAt time.Time
+	seen        seenClock
+}
+
+func (c *Client) info() PeerInfo {
+	connectedAt := c.connectedAt
+	return PeerInfo{
+		Version:        c.session.Version(),
+		ClientID:       c.ClientID,
+		DeviceInfo:     c.Device,
+		Address:        c.address,
+		Direction:      DirectionInbound,
+		State:          PeerConnected,
+		ConnectedSince: &connectedAt,
+		LastSeen:       c.seen.get(),
+	}
 }
 
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
+		c.session.close()
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadLimit(c.hub.maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.conn.SetPongHandler(func(string) error {
+		c.seen.touch()
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
 	for {
-		_, message, err := c.conn.ReadMessage()
+		message, err := readMessage(c.conn, c.hub.maxMessageSize)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				logger.Warnf("error: %v", err)
 			}
 			break
 		}
-		log.Printf("Received from client: %s", message)
+		c.seen.touch()
+		logger.Debugf("Received from client: %s", message)
 
-		HandleMessage(message)
+		HandleFrame(c.session, message)
 	}
 }
 
@@ -67,20 +103,7 @@ func (c *Client) writePump() {
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			if err := c.session.writeQueued(message, c.send); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -93,15 +116,65 @@ func (c *Client) writePump() {
 }
 
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	clientID := r.Header.Get("X-Client-ID")
+	// Peers sign their handshake, even when running on this machine
+	local := netutil.IsLoopback(r.RemoteAddr) && r.Header.Get(pairing.HeaderSignature) == ""
+
+	// Clients on this machine are trusted, remote peers must be paired. Web pages open
+	// in a browser are on this machine too; like the local API, they need an allowed origin.
+	if origin := r.Header.Get("Origin"); local && origin != "" && !slices.Contains(hub.allowedOrigins, origin) {
+		logger.Warnf("Rejected WebSocket connection from origin %s", origin)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !local {
+		verifiedID, err := hub.keys.VerifyRequest(r)
+		if err != nil {
+			logger.Warnf("Rejected WebSocket connection from %s (ClientID: %s): %v", r.RemoteAddr, clientID, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		clientID = verifiedID
+	}
+	device := pairing.DeviceInfoFrom(r.Header)
+	if !local {
+		hub.keys.NoteDeviceInfo(clientID, device)
+	}
+
+	// Tell the peer who it connected to
+	responseHeader := http.Header{}
+	if !local {
+		hub.keys.AddDeviceHeaders(responseHeader)
+	}
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
-		log.Println(err)
+		logger.Warnf("WebSocket upgrade from %s failed: %v", r.RemoteAddr, err)
 		return
 	}
-	clientID := r.Header.Get("X-Client-ID")
-	log.Printf("New WebSocket connection from ClientID: %s", clientID)
+	log.Printf("New WebSocket connection from %s (ClientID: %s, Platform: %s)", device.DeviceName, clientID, device.Platform)
 
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256), ClientID: clientID}
+	client := &Client{
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan []byte, 256),
+		ClientID:    clientID,
+		Device:      device,
+		local:       local,
+		address:     r.RemoteAddr,
+		localIP:     netutil.HostOf(conn.LocalAddr()),
+		connectedAt: time.Now(),
+	}
+	client.session = newSession(conn, hub, clientID, local, func(message []byte) bool {
+		return hub.sendTo(client, message)
+	})
+	if !local {
+		if err := client.session.sendHello(hub.selfID); err != nil {
+			logger.Warnf("Failed to send hello to %s: %v", clientID, err)
+			conn.Close()
+			return
+		}
+	}
+	client.seen.touch()
 	client.hub.register <- client
 
 	go client.writePump()
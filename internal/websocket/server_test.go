@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/web-service/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+// startHub serves a running hub's WebSocket endpoint and returns its ws:// URL.
+func startHub(t *testing.T, cfg *config.Config) (*Hub, string) {
+	t.Helper()
+	hub := NewHub(cfg, nil, nil)
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWs(hub, w, r)
+	}))
+	t.Cleanup(server.Close)
+	return hub, "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+func TestServeWsRefusesWebPages(t *testing.T) {
+	cfg := &config.Config{AllowedOrigins: config.StringList{"http://localhost:3000"}}
+	_, url := startHub(t, cfg)
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://localhost:3000", http.StatusSwitchingProtocols},
+		{"https://evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := http.Header{"X-Client-ID": {"agent"}}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("origin %q: %v", tt.origin, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("origin %q: status %d, want %d", tt.origin, resp.StatusCode, tt.want)
+		}
+	}
+}
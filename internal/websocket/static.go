@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/fsutil"
+	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
+	"example.com/web-service/internal/retry"
+)
+
+const staticPeersFile = "static_peers.json"
+
+var ErrConfiguredPeer = errors.New("peer is defined in the configuration")
+
+// staticPeer is a peer added by address, from the config or through the API.
+// Connections to static peers are retried forever.
+type staticPeer struct {
+	config.StaticPeer
+	fromConfig bool // Not saved; removed by editing the configuration
+}
+
+// LoadStaticPeers connects to the peers from the configuration and those added through the API.
+// Configured peers without a client ID are asked for it, retrying until they answer.
+func (m *ClientManager) LoadStaticPeers() {
+	var saved []config.StaticPeer
+	data, err := os.ReadFile(filepath.Join(m.cfg.DataDir, staticPeersFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			logger.Errorf("Failed to parse static peers: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		logger.Errorf("Failed to read static peers: %v", err)
+	}
+
+	for _, peer := range saved {
+		m.addStatic(staticPeer{StaticPeer: peer})
+	}
+	for _, peer := range m.cfg.Peers {
+		if peer.ClientID != "" {
+			m.addStatic(staticPeer{StaticPeer: peer, fromConfig: true})
+			continue
+		}
+		stop := make(chan struct{})
+		m.mu.Lock()
+		m.resolving[peer.Address] = stop
+		m.mu.Unlock()
+		go m.resolveStatic(peer, stop)
+	}
+}
+
+// resolveStatic asks a configured peer for its client ID until it answers, or until a
+// static peer at its address is added otherwise, which closes stop.
+func (m *ClientManager) resolveStatic(peer config.StaticPeer, stop chan struct{}) {
+	backoff := retry.NewBackoff(m.cfg.ReconnectInterval.Duration, m.cfg.ReconnectMaxInterval.Duration)
+	for {
+		id, device, err := pairing.Identify(peer.Address)
+		if err == nil {
+			peer.ClientID = id
+			m.hub.keys.NoteDiscovered(id, peer.Address, device, 0)
+			m.addStatic(staticPeer{StaticPeer: peer, fromConfig: true})
+			return
+		}
+
+		delay := backoff.Delay()
+		logger.Warnf("Failed to identify static peer %s: %v. Retrying in %v...", peer.Address, err, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			log.Printf("Stopped identifying static peer %s: added otherwise", peer.Address)
+			return
+		}
+	}
+}
+
+// AddStaticPeer remembers a peer by address, asking it for its client ID if not given,
+// and connects to it once it is paired.
+func (m *ClientManager) AddStaticPeer(peer config.StaticPeer) (config.StaticPeer, error) {
+	if peer.ClientID == "" {
+		id, device, err := pairing.Identify(peer.Address)
+		if err != nil {
+			return peer, err
+		}
+		peer.ClientID = id
+		m.hub.keys.NoteDiscovered(id, peer.Address, device, 0)
+	}
+
+	m.addStatic(staticPeer{StaticPeer: peer})
+	m.saveStatic()
+	return peer, nil
+}
+
+func (m *ClientManager) addStatic(peer staticPeer) {
+	m.mu.Lock()
+	if existing, ok := m.static[peer.ClientID]; ok && existing.fromConfig {
+		// The configuration wins over an earlier API call
+		peer.fromConfig = true
+	}
+	if stop, ok := m.resolving[peer.Address]; ok {
+		// A configured peer, now identified
+		delete(m.resolving, peer.Address)
+		close(stop)
+		peer.fromConfig = true
+	}
+	m.static[peer.ClientID] = peer
+	m.mu.Unlock()
+
+	m.hub.keys.NoteDiscovered(peer.ClientID, peer.Address, pairing.DeviceInfo{}, 0)
+	if m.hub.keys.IsPaired(peer.ClientID) {
+		m.ConnectToCloud(peer.Address, peer.ClientID)
+	} else {
+		log.Printf("Static peer %s (ClientID: %s) is not paired yet", peer.Address, peer.ClientID)
+	}
+}
+
+// RemoveStaticPeer forgets a peer added through the API and disconnects from it.
+func (m *ClientManager) RemoveStaticPeer(clientID string) (bool, error) {
+	m.mu.Lock()
+	peer, ok := m.static[clientID]
+	if !ok {
+		m.mu.Unlock()
+		return false, nil
+	}
+	if peer.fromConfig {
+		m.mu.Unlock()
+		return true, ErrConfiguredPeer
+	}
+	delete(m.static, clientID)
+	url := m.clientIDs[clientID]
+	client := m.clients[url]
+	m.mu.Unlock()
+
+	m.saveStatic()
+	if client != nil {
+		client.Close()
+		m.RemoveClient(url)
+	}
+	log.Printf("Removed static peer %s (ClientID: %s)", peer.Address, clientID)
+	return true, nil
+}
+
+// isStatic must be called with m.mu held.
+func (m *ClientManager) isStatic(clientID string) bool {
+	_, ok := m.static[clientID]
+	return ok
+}
+
+// unconnectedStaticLocked lists static peers without a client, for Peers.
+func (m *ClientManager) unconnectedStaticLocked() []PeerInfo {
+	var list []PeerInfo
+	for id, peer := range m.static {
+		if _, ok := m.clientIDs[id]; ok {
+			continue
+		}
+		list = append(list, PeerInfo{
+			ClientID:   id,
+			DeviceInfo: m.hub.keys.DeviceInfoOf(id),
+			Address:    peer.Address,
+			Direction:  DirectionOutbound,
+			State:      PeerDisconnected,
+			Static:     true,
+		})
+	}
+	return list
+}
+
+func (m *ClientManager) saveStatic() {
+	m.mu.RLock()
+	list := make([]config.StaticPeer, 0, len(m.static))
+	for _, peer := range m.static {
+		if !peer.fromConfig {
+			list = append(list, peer.StaticPeer)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		logger.Errorf("Failed to marshal static peers: %v", err)
+		return
+	}
+
+	if err := fsutil.WriteFileAtomic(filepath.Join(m.cfg.DataDir, staticPeersFile), data, 0600); err != nil {
+		logger.Errorf("Failed to save static peers: %v", err)
+	}
+}
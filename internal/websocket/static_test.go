@@ -0,0 +1,42 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"example.com/web-service/internal/config"
+	"example.com/web-service/internal/pairing"
+)
+
+func TestResolveStaticStopsWhenPeerIsAdded(t *testing.T) {
+	cfg := config.Default()
+	cfg.ClientID = "self"
+	cfg.DataDir = t.TempDir()
+	cfg.ReconnectInterval = config.Duration{Duration: 10 * time.Millisecond}
+	// Nothing listens on port 1, so identifying the peer keeps failing
+	cfg.Peers = config.StaticPeers{{Address: "127.0.0.1:1"}}
+	m := NewClientManager(cfg, NewHub(cfg, pairing.NewKeystore(cfg), nil))
+
+	m.LoadStaticPeers()
+	m.mu.RLock()
+	stop := m.resolving["127.0.0.1:1"]
+	m.mu.RUnlock()
+	if stop == nil {
+		t.Fatal("configured peer without a client ID is not being identified")
+	}
+
+	if _, err := m.AddStaticPeer(config.StaticPeer{ClientID: "peer", Address: "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stop:
+	default:
+		t.Fatal("identifying the peer did not stop after it was added")
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.static["peer"].fromConfig {
+		t.Error("configured peer added through the API was not kept as configured")
+	}
+}
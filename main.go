@@ -1,34 +1,62 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"example.com/web-service/internal/api"
+	"example.com/web-service/internal/config"
 	"example.com/web-service/internal/discovery"
 	"example.com/web-service/internal/lifecycle"
 	"example.com/web-service/internal/logger"
+	"example.com/web-service/internal/pairing"
 	"example.com/web-service/internal/server"
+	"example.com/web-service/internal/store"
 	"example.com/web-service/internal/websocket"
 )
 
 func main() {
-	logger.Setup()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+
+	logger.Setup(cfg.LogLevel)
 
 	// 监听 Stdin，如果关闭（父进程退出），则自动退出
 	lifecycle.WatchParentProcess()
 
+	// Load keys of paired peers and the clipboard history
+	keys := pairing.NewKeystore(cfg)
+	clipboard := store.New(cfg)
+
 	// Initialize WebSocket Hub
-	hub := websocket.NewHub()
+	hub := websocket.NewHub(cfg, keys, clipboard)
 	go hub.Run()
 
+	// Methods peers can call over their connection
+	api.RegisterMethods(cfg, clipboard, hub)
+
 	// Initialize Client Manager
-	clientManager := websocket.NewClientManager(hub)
+	clientManager := websocket.NewClientManager(cfg, hub)
+
+	// Connect to the peers added by address, from the config and through the API
+	clientManager.LoadStaticPeers()
 
-	// Start Discovery (Broadcasting)
-	// We don't need to listen here because server.StartUDP handles listening.
-	// We pass clientManager to server.StartUDP instead.
-	discovery.Start(clientManager)
+	// Start Discovery (broadcast announcements and mDNS)
+	// Broadcasts of other peers are received by server.StartUDP.
+	disc := discovery.New(cfg, keys, clientManager)
+	disc.Start()
 
-	// Start UDP server in a goroutine (Handles discovery responses too)
-	go server.StartUDP(clientManager)
+	// Start UDP server in a goroutine (Handles discovery broadcasts too)
+	go server.StartUDP(cfg, disc)
 
 	// Start HTTP server (blocking)
-	server.StartHTTP(hub, clientManager)
+	server.StartHTTP(cfg, keys, clipboard, hub, clientManager)
 }